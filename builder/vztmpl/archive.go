@@ -0,0 +1,399 @@
+package vztmpl
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// templateCompressions maps the template_compression values to the extension
+// of the archives they produce.
+var templateCompressions = map[string]string{
+	"gzip": "tar.gz",
+	"zstd": "tar.zst",
+	"xz":   "tar.xz",
+}
+
+// decompress returns a reader of the tar stream in r, detecting its
+// compression from the magic bytes.
+func decompress(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(6)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		d, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	case bytes.HasPrefix(magic, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}):
+		xr, err := xz.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(xr), nil
+	}
+	return io.NopCloser(br), nil
+}
+
+// compress returns a writer compressing to w with one of templateCompressions.
+// Deterministic compression doesn't depend on the number of CPUs.
+func compress(w io.Writer, compression string, deterministic bool) (io.WriteCloser, error) {
+	switch compression {
+	case "gzip":
+		return gzip.NewWriter(w), nil
+	case "zstd":
+		if deterministic {
+			return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+		}
+		return zstd.NewWriter(w)
+	case "xz":
+		return xz.NewWriter(w)
+	}
+	return nil, fmt.Errorf("unsupported compression %s", compression)
+}
+
+// initPaths are the paths, relative to the archive root, of the init programs
+// a container can boot with.
+var initPaths = []string{
+	"sbin/init",
+	"usr/sbin/init",
+	"lib/systemd/systemd",
+	"usr/lib/systemd/systemd",
+	"sbin/openrc-init",
+}
+
+// checkTemplateArchive reads the whole archive in r, checking that it is a
+// valid compressed tar stream holding an init program.
+func checkTemplateArchive(r io.Reader) error {
+	dr, err := decompress(r)
+	if err != nil {
+		return err
+	}
+	defer dr.Close()
+
+	found := false
+	tr := tar.NewReader(dr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("invalid tar archive: %s", err)
+		}
+		rel := archivePath(header.Name)
+		for _, p := range initPaths {
+			found = found || rel == p
+		}
+	}
+	if !found {
+		return fmt.Errorf("no init program found, looked for %s", strings.Join(initPaths, ", "))
+	}
+	return nil
+}
+
+// repackOptions configures repackArchive.
+type repackOptions struct {
+	// Glob patterns of the paths, relative to the archive root, to drop
+	exclude []string
+	// Sort the entries and clamp their modification times to sourceDateEpoch
+	reproducible    bool
+	sourceDateEpoch time.Time
+}
+
+// repackArchive copies the tar stream in r to w, dropping the vzdump metadata
+// and the excluded entries, and normalizing ownership like the official
+// templates: numeric only. vzdump already writes the IDs as seen from inside
+// the container, so they are kept as they are.
+//
+// Reproducible archives are spooled to a temporary file to be sorted.
+func repackArchive(r io.Reader, w io.Writer, opts repackOptions) error {
+	tr := tar.NewReader(r)
+	tw := tar.NewWriter(w)
+
+	var spool *archiveSpool
+	if opts.reproducible {
+		var err error
+		if spool, err = newArchiveSpool(); err != nil {
+			return err
+		}
+		defer spool.Close()
+	}
+	dropped := newDroppedEntries()
+	defer dropped.Close()
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		rel := archivePath(header.Name)
+		if rel == "etc/vzdump" || strings.HasPrefix(rel, "etc/vzdump/") ||
+			(rel != "" && isExcludedPath(rel, opts.exclude)) {
+			if err := dropped.add(header, tr); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var data io.Reader = tr
+		if header.Typeflag == tar.TypeLink {
+			if r := dropped.relink(header); r != nil {
+				data = r
+			}
+		}
+
+		normalizeHeader(header)
+		if spool != nil {
+			if header.ModTime.After(opts.sourceDateEpoch) {
+				header.ModTime = opts.sourceDateEpoch
+			}
+			header.ModTime = header.ModTime.Truncate(time.Second)
+			if err := spool.add(header, data); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, data); err != nil {
+			return err
+		}
+	}
+
+	if spool != nil {
+		if err := spool.writeSorted(tw); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// archivePath returns the path of an archive entry relative to its root,
+// empty for the root itself.
+func archivePath(name string) string {
+	rel := path.Clean("/" + name)
+	return strings.TrimPrefix(rel, "/")
+}
+
+// isExcludedPath reports whether rel, or one of its parent directories,
+// matches one of the patterns.
+func isExcludedPath(rel string, patterns []string) bool {
+	for p := rel; p != "." && p != "/"; p = path.Dir(p) {
+		for _, pattern := range patterns {
+			if matched, _ := path.Match(pattern, p); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// normalizeHeader drops the user and group names and the access and change
+// times of an entry. Extended attributes, such as file capabilities, are kept.
+func normalizeHeader(header *tar.Header) {
+	header.Uname = ""
+	header.Gname = ""
+	header.AccessTime = time.Time{}
+	header.ChangeTime = time.Time{}
+	header.Mode &= 07777
+	// Sparse files are read expanded, and written as regular files
+	if header.Typeflag == tar.TypeGNUSparse {
+		header.Typeflag = tar.TypeReg
+	}
+
+	records := make(map[string]string)
+	for k, v := range header.PAXRecords {
+		if strings.HasPrefix(k, "SCHILY.xattr.") {
+			records[k] = v
+		}
+	}
+	header.PAXRecords = records
+}
+
+// archiveSpool holds tar entries until they can be written in order, with
+// their data in a temporary file.
+type archiveSpool struct {
+	data    *os.File
+	size    int64
+	entries []*spooledEntry
+}
+
+type spooledEntry struct {
+	header *tar.Header
+	rel    string
+	offset int64
+}
+
+func newArchiveSpool() (*archiveSpool, error) {
+	data, err := os.CreateTemp("", "vztmpl-spool")
+	if err != nil {
+		return nil, err
+	}
+	return &archiveSpool{data: data}, nil
+}
+
+func (s *archiveSpool) add(header *tar.Header, r io.Reader) error {
+	n, err := io.Copy(s.data, r)
+	if err != nil {
+		return err
+	}
+	s.entries = append(s.entries, &spooledEntry{
+		header: header,
+		rel:    archivePath(header.Name),
+		offset: s.size,
+	})
+	s.size += n
+	return nil
+}
+
+// writeSorted writes the entries sorted by path. Hard links must come after
+// the entry holding their data, so the first entry of each group of hard
+// links gets it once sorted.
+func (s *archiveSpool) writeSorted(tw *tar.Writer) error {
+	sort.SliceStable(s.entries, func(i, j int) bool {
+		return s.entries[i].rel < s.entries[j].rel
+	})
+
+	byPath := make(map[string]*spooledEntry)
+	links := make(map[string][]*spooledEntry)
+	for _, e := range s.entries {
+		byPath[e.rel] = e
+		if e.header.Typeflag == tar.TypeLink {
+			target := archivePath(e.header.Linkname)
+			links[target] = append(links[target], e)
+		}
+	}
+	for target, group := range links {
+		t, ok := byPath[target]
+		if !ok || group[0].rel > t.rel {
+			continue
+		}
+		first := group[0]
+		dataHeader := *t.header
+		dataHeader.Name = first.header.Name
+		linkHeader := *first.header
+		linkHeader.Name = t.header.Name
+		first.header, t.header = &dataHeader, &linkHeader
+		first.offset = t.offset
+		for _, e := range append(group, t) {
+			if e != first {
+				e.header.Linkname = first.header.Name
+			}
+		}
+	}
+
+	for _, e := range s.entries {
+		if err := tw.WriteHeader(e.header); err != nil {
+			return err
+		}
+		if e.header.Typeflag == tar.TypeLink || e.header.Size == 0 {
+			continue
+		}
+		if _, err := io.Copy(tw, io.NewSectionReader(s.data, e.offset, e.header.Size)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close removes the temporary data file.
+func (s *archiveSpool) Close() error {
+	s.data.Close()
+	return os.Remove(s.data.Name())
+}
+
+// droppedEntries keeps the data of the dropped regular files, for the kept
+// hard links pointing at them.
+type droppedEntries struct {
+	spool  *archiveSpool
+	byPath map[string]*spooledEntry
+	// Kept entries now holding the data of a dropped file, by its path
+	holders map[string]string
+}
+
+func newDroppedEntries() *droppedEntries {
+	return &droppedEntries{
+		byPath:  make(map[string]*spooledEntry),
+		holders: make(map[string]string),
+	}
+}
+
+// add records a dropped entry, keeping the data of regular files. Dropped hard
+// links resolve to the entry holding their data.
+func (d *droppedEntries) add(header *tar.Header, r io.Reader) error {
+	rel := archivePath(header.Name)
+	switch header.Typeflag {
+	case tar.TypeLink:
+		if e, ok := d.byPath[archivePath(header.Linkname)]; ok {
+			d.byPath[rel] = e
+		}
+		return nil
+	case tar.TypeReg, tar.TypeGNUSparse:
+	default:
+		return nil
+	}
+
+	if d.spool == nil {
+		var err error
+		if d.spool, err = newArchiveSpool(); err != nil {
+			return err
+		}
+	}
+	if err := d.spool.add(header, r); err != nil {
+		return err
+	}
+	d.byPath[rel] = d.spool.entries[len(d.spool.entries)-1]
+	return nil
+}
+
+// relink updates a kept hard link pointing at a dropped file. The first one
+// becomes a regular file, and relink returns the reader of its data; the
+// following ones point at it.
+func (d *droppedEntries) relink(header *tar.Header) io.Reader {
+	target := archivePath(header.Linkname)
+	if holder, ok := d.holders[target]; ok {
+		header.Linkname = holder
+		return nil
+	}
+	e, ok := d.byPath[target]
+	if !ok {
+		return nil
+	}
+	d.holders[target] = header.Name
+	header.Typeflag = tar.TypeReg
+	header.Linkname = ""
+	header.Size = e.header.Size
+	return io.NewSectionReader(d.spool.data, e.offset, e.header.Size)
+}
+
+// Close removes the temporary data file, if any.
+func (d *droppedEntries) Close() error {
+	if d.spool == nil {
+		return nil
+	}
+	return d.spool.Close()
+}
@@ -0,0 +1,206 @@
+package vztmpl
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepackArchive(t *testing.T) {
+	var in bytes.Buffer
+	tw := tar.NewWriter(&in)
+	entries := []struct {
+		name string
+		uid  int
+		body string
+	}{
+		{"./", 0, ""},
+		{"./etc/", 0, ""},
+		{"./etc/vzdump/", 0, ""},
+		{"./etc/vzdump/pct.conf", 0, "arch: amd64"},
+		{"./etc/hostname", 0, "build"},
+		{"./var/cache/apt/", 0, ""},
+		{"./var/cache/apt/pkgcache.bin", 0, "cache"},
+		{"./home/user/file", 101000, "data"},
+	}
+	for _, e := range entries {
+		header := &tar.Header{
+			Name:  e.name,
+			Uid:   e.uid,
+			Gid:   e.uid,
+			Uname: "root",
+			Gname: "root",
+			Mode:  0644,
+			Size:  int64(len(e.body)),
+		}
+		if e.body == "" {
+			header.Typeflag = tar.TypeDir
+			header.Mode = 0755
+		}
+		require.NoError(t, tw.WriteHeader(header))
+		_, err := tw.Write([]byte(e.body))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+
+	var out bytes.Buffer
+	err := repackArchive(&in, &out, repackOptions{exclude: []string{"var/cache/apt/*"}})
+	require.NoError(t, err)
+
+	var names []string
+	tr := tar.NewReader(&out)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, header.Name)
+		require.Empty(t, header.Uname)
+
+		if header.Name == "./home/user/file" {
+			require.Equal(t, 101000, header.Uid)
+			body, err := io.ReadAll(tr)
+			require.NoError(t, err)
+			require.Equal(t, "data", string(body))
+		}
+	}
+	require.Equal(t, []string{"./", "./etc/", "./etc/hostname", "./var/cache/apt/", "./home/user/file"}, names)
+}
+
+func TestRepackArchiveReproducible(t *testing.T) {
+	build := func(order []int, mtime time.Time) []byte {
+		headers := []*tar.Header{
+			{Name: "./", Typeflag: tar.TypeDir, Mode: 0755},
+			{Name: "./usr/", Typeflag: tar.TypeDir, Mode: 0755},
+			{Name: "./usr/bin/b", Typeflag: tar.TypeReg, Mode: 0755, Size: 3},
+			{Name: "./usr/bin/a", Typeflag: tar.TypeLink, Linkname: "./usr/bin/b"},
+			{Name: "./usr/bin/", Typeflag: tar.TypeDir, Mode: 0755},
+		}
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for _, i := range order {
+			header := *headers[i]
+			header.ModTime = mtime
+			require.NoError(t, tw.WriteHeader(&header))
+			if header.Size > 0 {
+				_, err := tw.Write([]byte("bin"))
+				require.NoError(t, err)
+			}
+		}
+		require.NoError(t, tw.Close())
+
+		var out bytes.Buffer
+		opts := repackOptions{reproducible: true, sourceDateEpoch: time.Unix(1000, 0)}
+		require.NoError(t, repackArchive(&buf, &out, opts))
+		return out.Bytes()
+	}
+
+	first := build([]int{0, 1, 4, 2, 3}, time.Unix(2000, 500))
+	second := build([]int{0, 1, 2, 3, 4}, time.Unix(3000, 0))
+	require.Equal(t, first, second)
+
+	// The hard link now comes first, so it holds the data
+	tr := tar.NewReader(bytes.NewReader(first))
+	var names []string
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		require.Equal(t, int64(1000), header.ModTime.Unix())
+		names = append(names, header.Name)
+		switch header.Name {
+		case "./usr/bin/a":
+			require.Equal(t, byte(tar.TypeReg), header.Typeflag)
+		case "./usr/bin/b":
+			require.Equal(t, byte(tar.TypeLink), header.Typeflag)
+			require.Equal(t, "./usr/bin/a", header.Linkname)
+		}
+	}
+	require.Equal(t, []string{"./", "./usr/", "./usr/bin/", "./usr/bin/a", "./usr/bin/b"}, names)
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	for compression := range templateCompressions {
+		var buf bytes.Buffer
+		w, err := compress(&buf, compression, true)
+		require.NoError(t, err)
+		_, err = w.Write([]byte("template"))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		r, err := decompress(&buf)
+		require.NoError(t, err)
+		body, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, "template", string(body), compression)
+	}
+}
+
+func TestCheckTemplateArchive(t *testing.T) {
+	archive := func(names ...string) *bytes.Buffer {
+		var buf bytes.Buffer
+		gw, err := compress(&buf, "gzip", false)
+		require.NoError(t, err)
+		tw := tar.NewWriter(gw)
+		for _, name := range names {
+			require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeSymlink, Linkname: "/lib/systemd/systemd"}))
+		}
+		require.NoError(t, tw.Close())
+		require.NoError(t, gw.Close())
+		return &buf
+	}
+
+	require.NoError(t, checkTemplateArchive(archive("./", "./sbin/init")))
+	require.Error(t, checkTemplateArchive(archive("./", "./etc/hostname")))
+
+	truncated := archive("./", "./sbin/init")
+	truncated.Truncate(truncated.Len() / 2)
+	require.Error(t, checkTemplateArchive(truncated))
+}
+
+func TestRepackArchiveDroppedLinkTarget(t *testing.T) {
+	var in bytes.Buffer
+	tw := tar.NewWriter(&in)
+	headers := []*tar.Header{
+		{Name: "./var/cache/data", Typeflag: tar.TypeReg, Mode: 0644, Size: 4},
+		{Name: "./usr/b", Typeflag: tar.TypeLink, Linkname: "./var/cache/data"},
+		{Name: "./usr/a", Typeflag: tar.TypeLink, Linkname: "./var/cache/data"},
+	}
+	for _, header := range headers {
+		require.NoError(t, tw.WriteHeader(header))
+		if header.Size > 0 {
+			_, err := tw.Write([]byte("data"))
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, tw.Close())
+
+	for _, reproducible := range []bool{false, true} {
+		var out bytes.Buffer
+		opts := repackOptions{exclude: []string{"var/cache"}, reproducible: reproducible}
+		require.NoError(t, repackArchive(bytes.NewReader(in.Bytes()), &out, opts))
+
+		tr := tar.NewReader(&out)
+		first, err := tr.Next()
+		require.NoError(t, err)
+		require.Equal(t, byte(tar.TypeReg), first.Typeflag)
+		body, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		require.Equal(t, "data", string(body))
+
+		second, err := tr.Next()
+		require.NoError(t, err)
+		require.Equal(t, byte(tar.TypeLink), second.Typeflag)
+		require.Equal(t, first.Name, second.Linkname)
+
+		_, err = tr.Next()
+		require.Equal(t, io.EOF, err)
+	}
+}
@@ -3,13 +3,22 @@ package vztmpl
 import (
 	"fmt"
 	"log"
+	"strings"
 
 	"github.com/Telmate/proxmox-api-go/proxmox"
 )
 
 // packersdk.Artifact implementation
 type Artifact struct {
-	templatePath  string
+	templatePath string
+	digest       string
+	// Volume IDs of the template and of its distribute_to copies, prefixed
+	// with their node
+	volids []string
+	// Files written to output_directory
+	files []string
+	// URL of the template exported to s3_bucket
+	s3URL         string
 	proxmoxClient *proxmox.Client
 
 	// StateData should store data such as GeneratedData
@@ -22,6 +31,9 @@ func (*Artifact) BuilderId() string {
 }
 
 func (a *Artifact) Files() []string {
+	if len(a.files) > 0 {
+		return a.files
+	}
 	return []string{a.templatePath}
 }
 
@@ -30,10 +42,23 @@ func (a *Artifact) Id() string {
 }
 
 func (a *Artifact) String() string {
-	return fmt.Sprintf("A template was created: %s", a.templatePath)
+	s := fmt.Sprintf("A template was created: %s", a.templatePath)
+	if a.digest != "" {
+		s += fmt.Sprintf(" (%s)", a.digest)
+	}
+	if len(a.volids) > 1 {
+		s += fmt.Sprintf(", stored as %s", strings.Join(a.volids, ", "))
+	}
+	if a.s3URL != "" && a.s3URL != a.templatePath {
+		s += fmt.Sprintf(", exported to %s", a.s3URL)
+	}
+	return s
 }
 
 func (a *Artifact) State(name string) interface{} {
+	if name == "volids" {
+		return a.volids
+	}
 	if _, ok := a.StateData[name]; ok {
 		return a.StateData[name]
 	}
@@ -33,8 +33,8 @@ func (b *Builder) Prepare(raws ...interface{}) ([]string, []string, error) {
 	}
 	// Return the placeholder for the generated data that will become available to provisioners and post-processors.
 	// If the builder doesn't generate any data, just return an empty slice of string: []string{}
-	buildGeneratedData := []string{"GeneratedMockData"}
-	return buildGeneratedData, nil, nil
+	buildGeneratedData := []string{"GeneratedMockData", "TemplateFile", "TemplateContentDigest", "TemplateDigest"}
+	return buildGeneratedData, warnings, nil
 }
 
 func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook) (packersdk.Artifact, error) {
@@ -54,32 +54,64 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 	state.Put("ui", ui)
 
 	comm := &b.config.Comm
+	debugKeyPath := fmt.Sprintf("proxmox_lxc_%s.pem", b.config.PackerBuildName)
 
 	// Build the steps
 	var steps []multistep.Step
 
 	steps = append(steps,
 
-		&StepSshKeyPair{},
+		&StepSshKeyPair{
+			Debug:        b.config.PackerDebug,
+			DebugKeyPath: debugKeyPath,
+		},
+		&stepResolveTemplate{},
+		&stepCheckDistributeTargets{},
+		&stepDownloadTemplate{},
+		&stepUploadTemplate{},
+		&stepSDNVNet{},
+		&stepLeaseIP{},
 		&stepStartContainer{},
+		&stepFirewall{},
+		&stepBootstrapSSH{},
+		&stepSSHHostKeys{},
+		&stepDebugSummary{
+			DebugKeyPath: debugKeyPath,
+		},
 		commonsteps.HTTPServerFromHTTPConfig(&b.config.HTTPConfig),
+		&stepTypeBootCommand{},
 		&communicator.StepConnect{
 			Config:    &b.config.Comm,
-			Host:      commHost((*comm).Host()),
-			SSHConfig: (*comm).SSHConfigFunc(),
+			Host:      commHost(comm),
+			SSHConfig: sshConfigWithHostKeys(comm),
+			CustomConnect: map[string]multistep.Step{
+				"pct": &stepConnectPct{},
+			},
 		},
+		&stepHTTPTunnel{},
 		&commonsteps.StepProvision{},
 		&commonsteps.StepCleanupTempKeys{
 			Comm: &b.config.Comm,
 		},
+		&stepRevertSSHBootstrap{},
+		&stepGeneralize{},
+		&stepTemplateName{},
+		&stepRemoveFirewall{},
 		&stepConvertToBackup{},
+		&stepDownloadBackup{},
+		&stepRepackTemplate{},
+		&stepOutputTemplate{},
 		&stepSaveToTemplate{},
+		&stepDistributeTemplate{},
+		&stepExportS3{},
+		&stepRetention{},
 		&stepSuccess{})
 
 	// Set the value of the generated data that will become available to provisioners.
 	// To share the data with post-processors, use the StateData in the artifact.
 	state.Put("generated_data", map[string]interface{}{
 		"GeneratedMockData": "mock-build-data",
+		"TemplateFile":      b.config.TemplateFile,
 	})
 
 	// Run!
@@ -108,12 +140,26 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 		StateData:    map[string]interface{}{"generated_data": state.Get("generated_data")},
 		templatePath: templatePath,
 	}
+	if digest, ok := state.GetOk("templateDigest"); ok {
+		artifact.digest = digest.(string)
+	}
+	if volids, ok := state.GetOk("templateVolids"); ok {
+		artifact.volids = volids.([]string)
+	}
+	if files, ok := state.GetOk("outputFiles"); ok {
+		artifact.files = files.([]string)
+	}
+	if s3URL, ok := state.GetOk("s3URL"); ok {
+		artifact.s3URL = s3URL.(string)
+	}
 	return artifact, nil
 }
 
 // Returns ssh_host or winrm_host (see communicator.Config.Host) config
-func commHost(host string) func(state multistep.StateBag) (string, error) {
+func commHost(comm *communicator.Config) func(state multistep.StateBag) (string, error) {
 	return func(state multistep.StateBag) (string, error) {
-		return host, nil
+		// The host is only known once a provision_ip_pool address is leased,
+		// and the SDK joins host and port with a colon
+		return bracketHost(comm.Host()), nil
 	}
 }
@@ -0,0 +1,246 @@
+package vztmpl
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// pctCommunicator is a packersdk.Communicator running commands in the container
+// with pct exec, and transferring files with pct push and pct pull, over an SSH
+// connection to the node. It doesn't need any network or SSH server inside the
+// container.
+type pctCommunicator struct {
+	client *ssh.Client
+	sftp   *sftp.Client
+	vmid   int
+}
+
+var _ packersdk.Communicator = &pctCommunicator{}
+
+func newPctCommunicator(client *ssh.Client, vmid int) (*pctCommunicator, error) {
+	sftpClient, err := sftp.NewClient(client)
+	if err != nil {
+		return nil, err
+	}
+	return &pctCommunicator{
+		client: client,
+		sftp:   sftpClient,
+		vmid:   vmid,
+	}, nil
+}
+
+func (c *pctCommunicator) Start(ctx context.Context, cmd *packersdk.RemoteCmd) error {
+	session, err := c.client.NewSession()
+	if err != nil {
+		return err
+	}
+
+	session.Stdin = cmd.Stdin
+	session.Stdout = cmd.Stdout
+	session.Stderr = cmd.Stderr
+
+	command := fmt.Sprintf("pct exec %d -- sh -c %s", c.vmid, shellQuote(cmd.Command))
+	log.Printf("[DEBUG] starting remote command: %s", command)
+	if err := session.Start(command); err != nil {
+		session.Close()
+		return err
+	}
+
+	go func() {
+		defer session.Close()
+
+		err := session.Wait()
+		exitStatus := 0
+		if err != nil {
+			switch err := err.(type) {
+			case *ssh.ExitError:
+				exitStatus = err.ExitStatus()
+				log.Printf("[ERROR] Remote command exited with '%d': %s", exitStatus, cmd.Command)
+			default:
+				log.Printf("[ERROR] Error occurred waiting for pct exec: %s", err)
+				exitStatus = packersdk.CmdDisconnect
+			}
+		}
+		cmd.SetExited(exitStatus)
+	}()
+	return nil
+}
+
+func (c *pctCommunicator) Upload(dst string, r io.Reader, fi *os.FileInfo) error {
+	tmpPath, err := c.nodeTempFile()
+	if err != nil {
+		return err
+	}
+	defer c.sftp.Remove(tmpPath)
+
+	f, err := c.sftp.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if _, err := f.ReadFrom(r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	command := fmt.Sprintf("pct push %d %s %s", c.vmid, shellQuote(tmpPath), shellQuote(dst))
+	if fi != nil {
+		command += fmt.Sprintf(" --perms %o", (*fi).Mode().Perm())
+	}
+	_, err = runNodeCommand(c.client, command)
+	return err
+}
+
+func (c *pctCommunicator) UploadDir(dst string, src string, exclude []string) error {
+	// Like rsync, only the content of src is uploaded when it ends with a slash
+	if !strings.HasSuffix(src, "/") {
+		dst = path.Join(dst, filepath.Base(src))
+	}
+
+	return filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		if isExcluded(rel, exclude) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		target := path.Join(dst, filepath.ToSlash(rel))
+		if info.IsDir() {
+			_, err := runContainerCommand(c.client, c.vmid, "mkdir -p "+shellQuote(target))
+			return err
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return c.Upload(target, f, &info)
+	})
+}
+
+func (c *pctCommunicator) Download(src string, w io.Writer) error {
+	tmpPath, err := c.nodeTempFile()
+	if err != nil {
+		return err
+	}
+	defer c.sftp.Remove(tmpPath)
+
+	command := fmt.Sprintf("pct pull %d %s %s", c.vmid, shellQuote(src), shellQuote(tmpPath))
+	if _, err := runNodeCommand(c.client, command); err != nil {
+		return err
+	}
+
+	f, err := c.sftp.Open(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
+
+func (c *pctCommunicator) DownloadDir(src string, dst string, exclude []string) error {
+	session, err := c.client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	command := fmt.Sprintf("pct exec %d -- tar -C %s -cf - .", c.vmid, shellQuote(src))
+	if err := session.Start(command); err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(stdout)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		rel := path.Clean(header.Name)
+		if rel == "." || isExcluded(rel, exclude) {
+			continue
+		}
+		if strings.HasPrefix(rel, "../") {
+			return fmt.Errorf("refusing to extract %s outside of %s", header.Name, dst)
+		}
+
+		target := filepath.Join(dst, filepath.FromSlash(rel))
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode).Perm())
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return session.Wait()
+}
+
+// Close releases the SFTP session and the node connection.
+func (c *pctCommunicator) Close() error {
+	c.sftp.Close()
+	return c.client.Close()
+}
+
+func (c *pctCommunicator) nodeTempFile() (string, error) {
+	out, err := runNodeCommand(c.client, "mktemp /tmp/packer-pct.XXXXXXXX")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// isExcluded reports whether the relative path matches one of the exclude patterns.
+func isExcluded(rel string, exclude []string) bool {
+	for _, pattern := range exclude {
+		if matched, _ := filepath.Match(pattern, rel); matched {
+			return true
+		}
+	}
+	return false
+}
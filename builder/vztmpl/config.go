@@ -8,11 +8,16 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/Telmate/proxmox-api-go/proxmox"
 	"github.com/hashicorp/packer-plugin-sdk/bootcommand"
 	"github.com/hashicorp/packer-plugin-sdk/common"
 	"github.com/hashicorp/packer-plugin-sdk/communicator"
@@ -27,8 +32,10 @@ type Config struct {
 	common.PackerConfig    `mapstructure:",squash"`
 	commonsteps.HTTPConfig `mapstructure:",squash"`
 	bootcommand.BootConfig `mapstructure:",squash"`
-	BootKeyInterval        time.Duration       `mapstructure:"boot_key_interval"`
-	Comm                   communicator.Config `mapstructure:",squash"`
+	// Time to wait between the keys of boot_command typed on the container
+	// console. Defaults to `10ms`.
+	BootKeyInterval time.Duration       `mapstructure:"boot_key_interval"`
+	Comm            communicator.Config `mapstructure:",squash"`
 
 	ProxmoxURLRaw      string `mapstructure:"proxmox_url"`
 	proxmoxURL         *url.URL
@@ -40,11 +47,125 @@ type Config struct {
 	Pool               string        `mapstructure:"pool"`
 	TaskTimeout        time.Duration `mapstructure:"task_timeout"`
 
-	Memory         int    `mapstructure:"memory"`
-	Cores          int    `mapstructure:"cores"`
-	Unprivileged   bool   `mapstructure:"unprivileged"`
-	TemplateFile   string `mapstructure:"template_file"`
-	TemplateSuffix string `mapstructure:"template_suffix"`
+	Memory       int  `mapstructure:"memory"`
+	Cores        int  `mapstructure:"cores"`
+	Unprivileged bool `mapstructure:"unprivileged"`
+	// Name of the base template in template_storage_pool, or path to a local
+	// template archive (or to a directory holding one) to upload there.
+	TemplateFile      string `mapstructure:"template_file"`
+	templateLocalPath string
+	TemplateSuffix    string `mapstructure:"template_suffix"`
+	// Name of the saved template, without extension. Supports interpolation
+	// with `{{ .BuildName }}`, `{{ .VMID }}`, `{{ .BaseName }}` (the base
+	// template, cloned container or restored backup), and `{{ .OS }}`,
+	// `{{ .OSVersion }}` and `{{ .Arch }}` read from the container, along with
+	// functions such as `{{ timestamp }}`. Defaults to
+	// `<base name>_<template_suffix>`.
+	TemplateName string `mapstructure:"template_name"`
+	// Name the saved template after the appliance template convention,
+	// `<os>-<version>-<template_suffix>_<template_release>_<arch>`, with the
+	// OS, version and architecture of the container.
+	TemplateApplianceName bool `mapstructure:"template_appliance_name"`
+	// Release part of the name with template_appliance_name. Defaults to `1`.
+	TemplateRelease string `mapstructure:"template_release"`
+	// What to do when a template with the same name, whatever its extension,
+	// is already in template_storage_pool or a distribute_to storage: `fail`
+	// the build before the container is exported, `overwrite` it once the new
+	// template is uploaded and verified, or `version` the name with the next
+	// release number free in all of them (a `-<n>` suffix without
+	// template_appliance_name). Defaults to `overwrite`.
+	OnExistingTemplate string `mapstructure:"on_existing_template"`
+	// Number of the newest templates of this build to keep in
+	// template_storage_pool once the template is saved. Older ones are deleted
+	// unless kept by keep_days. `0` keeps them all. Only templates a container
+	// is being created from are protected: Proxmox doesn't record the
+	// template existing containers were created from.
+	KeepLast int `mapstructure:"keep_last"`
+	// Number of days to keep the templates of this build in
+	// template_storage_pool once the template is saved. Older ones are deleted
+	// unless kept by keep_last. `0` keeps them all.
+	KeepDays int `mapstructure:"keep_days"`
+	// Glob pattern of the names, without extension, of the templates of this
+	// build considered by keep_last and keep_days. Defaults to the saved name
+	// with any `-<n>` version suffix, or to any release with
+	// template_appliance_name. Required with template_name.
+	RetentionPattern string `mapstructure:"retention_pattern"`
+	// Only list the templates keep_last and keep_days would delete.
+	RetentionDryRun bool `mapstructure:"retention_dry_run"`
+	// Template storages to copy the saved template to, in parallel, as
+	// `<node>:<storage>`, or `<storage>` for a storage of node. Useful when
+	// the template storage is local to each node. When a copy fails, the
+	// others are deleted.
+	DistributeTo      []string `mapstructure:"distribute_to"`
+	distributeTargets []distributeTarget
+	// Directory to keep a copy of the template archive in, along with its
+	// sha256 checksum and a JSON metadata file. It must not exist, unless
+	// `-force` is used.
+	OutputDirectory string `mapstructure:"output_directory"`
+	// Don't upload the template to template_storage_pool, only keeping it in
+	// output_directory or s3_bucket.
+	SkipUpload bool `mapstructure:"skip_upload"`
+
+	// Bucket of an S3-compatible object store to export the template archive
+	// to, along with `.sha256` and `.json` objects like in output_directory.
+	S3Bucket string `mapstructure:"s3_bucket"`
+	// Key of the template archive in s3_bucket. Supports interpolation with
+	// `{{ .BuildName }}`, `{{ .Name }}` (the template name, without
+	// extension) and `{{ .File }}`. Defaults to `{{ .File }}`.
+	S3Key string `mapstructure:"s3_key"`
+	// Endpoint URL of the object store, e.g. `http://127.0.0.1:9000` for a
+	// local MinIO. Defaults to AWS S3.
+	S3Endpoint string `mapstructure:"s3_endpoint"`
+	// Region of s3_bucket. Defaults to `us-east-1`.
+	S3Region string `mapstructure:"s3_region"`
+	// Access key of the object store. Defaults to the AWS SDK credential
+	// chain: environment, shared credentials file, instance role.
+	S3AccessKey string `mapstructure:"s3_access_key"`
+	// Secret key of the object store, with s3_access_key.
+	S3SecretKey string `mapstructure:"s3_secret_key"`
+	// Address the bucket in the URL path instead of the host name, as
+	// needed by most S3-compatible stores. Defaults to `true` with
+	// s3_endpoint.
+	S3ForcePathStyle config.Trilean `mapstructure:"s3_force_path_style"`
+	// Server-side encryption of the uploaded objects, `AES256` or `aws:kms`.
+	S3ServerSideEncryption string `mapstructure:"s3_server_side_encryption"`
+	// KMS key to encrypt the objects with, with `aws:kms`.
+	S3KMSKeyID string `mapstructure:"s3_kms_key_id"`
+	// Size in MiB of the parts of the multipart upload. Defaults to `64`.
+	S3PartSize int `mapstructure:"s3_part_size"`
+
+	// URL the base template is fetched from when template_file is not
+	// already present in template_storage_pool.
+	TemplateURL string `mapstructure:"template_url"`
+	// Checksum of the file behind template_url or of the local template_file,
+	// in the form `type:value` (e.g. `sha256:...`). Use `none` to skip
+	// verification.
+	TemplateChecksum string `mapstructure:"template_checksum"`
+	// Time to wait for the node to download template_url. Defaults to `30m`.
+	TemplateDownloadTimeout time.Duration `mapstructure:"template_download_timeout"`
+	// Operating system of the base template to resolve from the node's
+	// appliance index and the template storage, e.g. `debian`. The newest
+	// matching template is used and downloaded when needed.
+	TemplateOS string `mapstructure:"template_os"`
+	// Version of the base template to resolve along with template_os, e.g.
+	// `11` or `22.04`. Any version matches when left empty.
+	TemplateVersion string `mapstructure:"template_version"`
+
+	// ID of an existing container or container template to start the build
+	// from with a full clone, instead of an ostemplate archive.
+	CloneVMID int `mapstructure:"clone_vmid"`
+	// Name of the container or container template to clone. Alternative to
+	// clone_vmid.
+	CloneName string `mapstructure:"clone_name"`
+	// Name of the snapshot of the cloned container to start from.
+	CloneSnapshot string `mapstructure:"clone_snapshot"`
+
+	// Volume ID of a vzdump backup to restore the build container from,
+	// e.g. `local:backup/vzdump-lxc-100-2023_01_01-00_00_00.tar.zst`.
+	RestoreFromBackup string `mapstructure:"restore_from_backup"`
+	// ID of a container whose latest backup in backup_storage_pool the build
+	// container is restored from. Alternative to restore_from_backup.
+	RestoreFromVMID int `mapstructure:"restore_from_vmid"`
 
 	TemplateStoragePool string `mapstructure:"template_storage_pool"`
 	BackupStoragePool   string `mapstructure:"backup_storage_pool"`
@@ -52,9 +173,108 @@ type Config struct {
 	FSSize              int    `mapstructure:"filesystem_size"`
 	VMID                int    `mapstructure:"vmid"`
 
+	// IPv4 address of the provisioning interface, optionally with its prefix
+	// length. Defaults to a /24 network when no prefix length is given.
 	ProvisionIP        string `mapstructure:"provision_ip"`
 	ProvisionGatewayIP string `mapstructure:"provision_gateway_ip"`
-	ProvisionMac       string `mapstructure:"provision_mac"`
+	// Network, in CIDR notation, the provisioning IPv4 address is leased from
+	// instead of using provision_ip, e.g. `10.0.0.0/24`. Addresses configured
+	// on the containers and VMs of the cluster, and those leased by other
+	// builds, are skipped. Leases are kept in /etc/pve/packer-ip-leases on
+	// the node until the build ends.
+	ProvisionIPPool string `mapstructure:"provision_ip_pool"`
+	// Range of provision_ip_pool addresses that can be leased, as
+	// `first-last`, e.g. `10.0.0.100-10.0.0.150`. Defaults to the whole
+	// network.
+	ProvisionIPPoolRange string `mapstructure:"provision_ip_pool_range"`
+	// IPv6 configuration of the provisioning interface: a static address with
+	// its prefix length (e.g. `fd00::10/64`), `dhcp`, or `auto` for SLAAC. The
+	// communicator connects over IPv6 when provision_ip is not set, which
+	// requires a static address.
+	ProvisionIP6 string `mapstructure:"provision_ip6"`
+	// IPv6 gateway of the provisioning interface, with a static provision_ip6.
+	ProvisionGatewayIP6 string `mapstructure:"provision_gateway_ip6"`
+	ProvisionMac        string `mapstructure:"provision_mac"`
+
+	// SDN zone of sdn_vnet, checked when set. Required with
+	// sdn_ephemeral_vnet.
+	SDNZone string `mapstructure:"sdn_zone"`
+	// SDN VNet the provisioning interface is attached to, instead of the
+	// vmbr0 bridge.
+	SDNVNet string `mapstructure:"sdn_vnet"`
+	// Create sdn_vnet in sdn_zone for the build, with the sdn_subnet subnet,
+	// and delete it afterwards. A name is generated when sdn_vnet is empty.
+	SDNEphemeralVNet bool `mapstructure:"sdn_ephemeral_vnet"`
+	// Subnet, in CIDR notation, of the ephemeral VNet. Its gateway is
+	// provision_gateway_ip.
+	SDNSubnet string `mapstructure:"sdn_subnet"`
+
+	// Reach the container through the Proxmox node, using it as SSH bastion
+	// with the node credentials, for provisioning networks that aren't
+	// routable from the Packer host. The HTTP server is forwarded to
+	// 127.0.0.1 in the container, so provisioners can still fetch files from
+	// it, but boot_command, which runs before, can't use `{{ .HTTPIP }}`.
+	ProvisionViaNode bool `mapstructure:"provision_via_node"`
+
+	// Enable the Proxmox firewall on the build container, with temporary rules
+	// only accepting SSH connections from the Packer host, or from the
+	// address of the node on the bridge of the container with
+	// provision_via_node. The rules are removed before the container is
+	// exported. VM firewall rules only apply when the firewall is enabled for
+	// the datacenter.
+	Firewall bool `mapstructure:"firewall"`
+	// Address or CIDR SSH connections are accepted from while firewall is
+	// enabled, when the default doesn't fit, e.g. with a node connecting from
+	// another address than the one on the bridge.
+	FirewallSSHSource string `mapstructure:"firewall_ssh_source"`
+	// Destinations, as IP addresses, CIDRs or IPSet references, the container
+	// may connect to while firewall is enabled, on top of the Packer host.
+	// Outgoing connections are not filtered when left empty.
+	FirewallEgressAllow []string `mapstructure:"firewall_egress_allow"`
+
+	// Install and start an SSH server allowing root logins with keys through
+	// pct exec before connecting, for minimal base templates shipping
+	// without one.
+	SSHBootstrap bool `mapstructure:"ssh_bootstrap"`
+	// Undo the changes of ssh_bootstrap before the container is exported:
+	// remove the SSH server if it was installed, or disable it if it wasn't
+	// enabled, restore sshd_config and delete the generated host keys.
+	SSHBootstrapRevert bool `mapstructure:"ssh_bootstrap_revert"`
+	// Read the SSH host keys generated in the container through pct exec on
+	// the node, and only accept those when connecting, instead of trusting
+	// any key.
+	PinSSHHostKeys bool `mapstructure:"pin_ssh_host_keys"`
+
+	// Remove the identity of the build container and the state it
+	// accumulated before it is exported: machine-id, SSH host keys, package
+	// caches, logs, shell history, DHCP leases, resolv.conf and hostname.
+	Generalize bool `mapstructure:"generalize"`
+	// Items of generalize to leave untouched, among `machine_id`,
+	// `ssh_host_keys`, `package_cache`, `logs`, `shell_history`,
+	// `dhcp_leases`, `resolv_conf` and `hostname`.
+	GeneralizeSkip []string `mapstructure:"generalize_skip"`
+	// Shell commands run in the container before it is exported, after the
+	// generalize items.
+	GeneralizeCommands []string `mapstructure:"generalize_commands"`
+
+	// Repack the vzdump archive into a clean template archive: the vzdump
+	// metadata and the repack_exclude entries are dropped, and ownership is
+	// made numeric, like in the official templates.
+	Repack bool `mapstructure:"repack"`
+	// Glob patterns, relative to the container root (e.g. `var/cache/apt`),
+	// of the entries dropped when repacking. Matching directories are
+	// dropped with their content.
+	RepackExclude []string `mapstructure:"repack_exclude"`
+	// Compression of the repacked archive: `gzip`, `zstd` or `xz`. Defaults
+	// to `gzip`.
+	TemplateCompression string `mapstructure:"template_compression"`
+	// Produce the same archive from the same inputs: entries are sorted, their
+	// modification times clamped to source_date_epoch, and compression doesn't
+	// depend on the host. Implies repack.
+	Reproducible bool `mapstructure:"reproducible"`
+	// Unix time the modification times are clamped to with reproducible.
+	// Defaults to the `SOURCE_DATE_EPOCH` environment variable, or 0.
+	SourceDateEpoch int64 `mapstructure:"source_date_epoch"`
 
 	ctx interpolate.Context
 }
@@ -69,6 +289,8 @@ func (c *Config) Prepare(raws ...interface{}) ([]string, error) {
 		InterpolateFilter: &interpolate.RenderFilter{
 			Exclude: []string{
 				"boot_command",
+				"template_name",
+				"s3_key",
 			},
 		},
 	}, raws...)
@@ -77,6 +299,7 @@ func (c *Config) Prepare(raws ...interface{}) ([]string, error) {
 	}
 
 	var errs *packer.MultiError
+	var warnings []string
 	// Defaults
 	if c.ProxmoxURLRaw == "" {
 		c.ProxmoxURLRaw = os.Getenv("PROXMOX_URL")
@@ -93,6 +316,9 @@ func (c *Config) Prepare(raws ...interface{}) ([]string, error) {
 	if c.TaskTimeout == 0 {
 		c.TaskTimeout = 60 * time.Second
 	}
+	if c.TemplateDownloadTimeout == 0 {
+		c.TemplateDownloadTimeout = 30 * time.Minute
+	}
 	if c.Memory < 16 {
 		log.Printf("Memory %d is too small, using default: 512", c.Memory)
 		c.Memory = 512
@@ -102,7 +328,8 @@ func (c *Config) Prepare(raws ...interface{}) ([]string, error) {
 		c.Cores = 1
 	}
 
-	if c.ProvisionMac == "" {
+	// A MAC address is derived from the leased address otherwise
+	if c.ProvisionMac == "" && c.ProvisionIPPool == "" {
 		c.ProvisionMac = "1e:eb:08:d1:e7:e2"
 	}
 
@@ -126,6 +353,74 @@ func (c *Config) Prepare(raws ...interface{}) ([]string, error) {
 	if c.Node == "" {
 		errs = packer.MultiErrorAppend(errs, errors.New("node must be specified"))
 	}
+	if c.TemplateURL != "" {
+		if c.TemplateFile == "" {
+			u, err := url.Parse(c.TemplateURL)
+			if err != nil {
+				errs = packer.MultiErrorAppend(errs, fmt.Errorf("could not parse template_url: %s", err))
+			} else {
+				c.TemplateFile = path.Base(u.Path)
+			}
+		}
+		if c.TemplateChecksum == "" {
+			errs = packer.MultiErrorAppend(errs, errors.New("template_checksum must be specified with template_url"))
+		}
+	} else if c.TemplateFile != "" {
+		// template_file may point to a local file, or to the output directory
+		// of a previous build, that gets uploaded to the template storage
+		if info, err := os.Stat(c.TemplateFile); err == nil {
+			localPath := c.TemplateFile
+			if info.IsDir() {
+				localPath, err = findTemplateArchive(c.TemplateFile)
+			}
+			if err != nil {
+				errs = packer.MultiErrorAppend(errs, fmt.Errorf("could not find template_file: %s", err))
+			} else {
+				c.templateLocalPath = localPath
+				c.TemplateFile = filepath.Base(localPath)
+			}
+		} else if strings.ContainsRune(c.TemplateFile, '/') {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("template_file %s does not exist", c.TemplateFile))
+		}
+	}
+	switch {
+	case c.TemplateChecksum == "":
+	case c.TemplateURL == "" && c.templateLocalPath == "":
+		errs = packer.MultiErrorAppend(errs, errors.New("template_checksum requires template_url or a local template_file"))
+	case strings.ToLower(c.TemplateChecksum) == "none":
+		warnings = append(warnings, "template_checksum is set to none, the template will not be verified")
+	default:
+		if _, _, err := parseChecksum(c.TemplateChecksum); err != nil {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("invalid template_checksum: %s", err))
+		}
+	}
+	if c.TemplateOS != "" && (c.TemplateFile != "" || c.TemplateURL != "") {
+		errs = packer.MultiErrorAppend(errs, errors.New("template_os can't be used with template_file or template_url"))
+	}
+	if c.TemplateVersion != "" && c.TemplateOS == "" {
+		errs = packer.MultiErrorAppend(errs, errors.New("template_version requires template_os"))
+	}
+	if c.CloneVMID != 0 || c.CloneName != "" {
+		if c.CloneVMID != 0 && c.CloneName != "" {
+			errs = packer.MultiErrorAppend(errs, errors.New("clone_vmid and clone_name are mutually exclusive"))
+		}
+		if c.TemplateFile != "" || c.TemplateURL != "" || c.TemplateOS != "" {
+			errs = packer.MultiErrorAppend(errs, errors.New("cloning can't be used with template_file, template_url or template_os"))
+		}
+	} else if c.CloneSnapshot != "" {
+		errs = packer.MultiErrorAppend(errs, errors.New("clone_snapshot requires clone_vmid or clone_name"))
+	}
+	if c.RestoreFromBackup != "" || c.RestoreFromVMID != 0 {
+		if c.RestoreFromBackup != "" && c.RestoreFromVMID != 0 {
+			errs = packer.MultiErrorAppend(errs, errors.New("restore_from_backup and restore_from_vmid are mutually exclusive"))
+		}
+		if c.TemplateFile != "" || c.TemplateURL != "" || c.TemplateOS != "" || c.CloneVMID != 0 || c.CloneName != "" {
+			errs = packer.MultiErrorAppend(errs, errors.New("restoring a backup can't be used with template_file, template_url, template_os or cloning"))
+		}
+		if c.RestoreFromVMID != 0 && c.BackupStoragePool == "" {
+			errs = packer.MultiErrorAppend(errs, errors.New("restore_from_vmid requires backup_storage_pool"))
+		}
+	}
 	if strings.ContainsAny(c.TemplateFile, " ") {
 		errs = packer.MultiErrorAppend(errs, errors.New("template_name must not contain spaces"))
 	}
@@ -136,31 +431,274 @@ func (c *Config) Prepare(raws ...interface{}) ([]string, error) {
 		errs = packer.MultiErrorAppend(errs, errors.New("filesystem_size must be specified"))
 	}
 
-	if c.TemplateSuffix == "" {
+	if c.TemplateSuffix == "" && c.TemplateName == "" {
 		errs = packer.MultiErrorAppend(errs, errors.New("template_suffix must be specified"))
 
 	}
+	if c.TemplateName != "" {
+		if c.TemplateApplianceName {
+			errs = packer.MultiErrorAppend(errs, errors.New("template_name and template_appliance_name are mutually exclusive"))
+		}
+		if err := interpolate.Validate(c.TemplateName, &c.ctx); err != nil {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("invalid template_name: %s", err))
+		}
+	}
+	if c.TemplateApplianceName {
+		if c.TemplateRelease == "" {
+			c.TemplateRelease = "1"
+		}
+		if strings.ContainsAny(c.TemplateSuffix+c.TemplateRelease, "_") {
+			errs = packer.MultiErrorAppend(errs, errors.New("template_suffix and template_release must not contain underscores with template_appliance_name"))
+		}
+	} else if c.TemplateRelease != "" {
+		errs = packer.MultiErrorAppend(errs, errors.New("template_release requires template_appliance_name"))
+	}
+	if c.OnExistingTemplate == "" {
+		c.OnExistingTemplate = "overwrite"
+	}
+	validPolicy := false
+	for _, policy := range onExistingTemplatePolicies {
+		validPolicy = validPolicy || policy == c.OnExistingTemplate
+	}
+	if !validPolicy {
+		errs = packer.MultiErrorAppend(errs, fmt.Errorf("on_existing_template must be one of %s, not %s", strings.Join(onExistingTemplatePolicies, ", "), c.OnExistingTemplate))
+	}
+	if c.KeepLast < 0 || c.KeepDays < 0 {
+		errs = packer.MultiErrorAppend(errs, errors.New("keep_last and keep_days must be positive"))
+	}
+	if c.KeepLast > 0 || c.KeepDays > 0 {
+		if c.RetentionPattern == "" && c.TemplateName != "" {
+			errs = packer.MultiErrorAppend(errs, errors.New("retention_pattern must be specified with template_name"))
+		}
+		if _, err := path.Match(c.RetentionPattern, ""); err != nil {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("invalid retention_pattern: %s", err))
+		}
+	} else if c.RetentionPattern != "" || c.RetentionDryRun {
+		errs = packer.MultiErrorAppend(errs, errors.New("retention_pattern and retention_dry_run require keep_last or keep_days"))
+	}
+	c.distributeTargets = nil
+	seenTargets := map[distributeTarget]bool{{Node: c.Node, Storage: c.TemplateStoragePool}: true}
+	for _, target := range c.DistributeTo {
+		t, err := parseDistributeTarget(target, c.Node)
+		if err != nil {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("invalid distribute_to: %s", err))
+			continue
+		}
+		if seenTargets[t] {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("distribute_to %s is listed twice or is the template storage the template is saved to", target))
+			continue
+		}
+		seenTargets[t] = true
+		c.distributeTargets = append(c.distributeTargets, t)
+	}
+	if c.OutputDirectory != "" && !c.PackerForce {
+		if _, err := os.Stat(c.OutputDirectory); err == nil {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("output_directory %s already exists, use -force to overwrite it", c.OutputDirectory))
+		}
+	}
+	if c.S3Bucket != "" {
+		if c.S3Key == "" {
+			c.S3Key = "{{ .File }}"
+		}
+		if err := interpolate.Validate(c.S3Key, &c.ctx); err != nil {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("invalid s3_key: %s", err))
+		}
+		if c.S3Region == "" {
+			c.S3Region = "us-east-1"
+		}
+		if c.S3ForcePathStyle == config.TriUnset && c.S3Endpoint != "" {
+			c.S3ForcePathStyle = config.TriTrue
+		}
+		if (c.S3AccessKey == "") != (c.S3SecretKey == "") {
+			errs = packer.MultiErrorAppend(errs, errors.New("s3_access_key and s3_secret_key must be specified together"))
+		}
+		switch c.S3ServerSideEncryption {
+		case "", "AES256":
+			if c.S3KMSKeyID != "" {
+				errs = packer.MultiErrorAppend(errs, errors.New("s3_kms_key_id requires s3_server_side_encryption aws:kms"))
+			}
+		case "aws:kms":
+		default:
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("s3_server_side_encryption must be AES256 or aws:kms, not %s", c.S3ServerSideEncryption))
+		}
+		if c.S3PartSize == 0 {
+			c.S3PartSize = 64
+		}
+		if c.S3PartSize < 5 {
+			errs = packer.MultiErrorAppend(errs, errors.New("s3_part_size must be at least 5 MiB"))
+		}
+	} else if c.S3Key != "" || c.S3Endpoint != "" || c.S3AccessKey != "" || c.S3ServerSideEncryption != "" {
+		errs = packer.MultiErrorAppend(errs, errors.New("s3_key, s3_endpoint, s3_access_key and s3_server_side_encryption require s3_bucket"))
+	}
+	if c.SkipUpload {
+		if c.OutputDirectory == "" && c.S3Bucket == "" {
+			errs = packer.MultiErrorAppend(errs, errors.New("skip_upload requires output_directory or s3_bucket"))
+		}
+		if len(c.DistributeTo) > 0 || c.KeepLast > 0 || c.KeepDays > 0 {
+			errs = packer.MultiErrorAppend(errs, errors.New("skip_upload can't be used with distribute_to, keep_last or keep_days"))
+		}
+	}
 
-	if c.ProvisionIP == "" {
-		errs = packer.MultiErrorAppend(errs, errors.New("provision_ip must be specified"))
+	if c.ProvisionIP != "" && !validProvisionIP(c.ProvisionIP) {
+		errs = packer.MultiErrorAppend(errs, errors.New("provision_ip must be an IPv4 address, optionally with its prefix length"))
+	}
+	if c.ProvisionIPPool != "" {
+		if c.ProvisionIP != "" {
+			errs = packer.MultiErrorAppend(errs, errors.New("provision_ip and provision_ip_pool are mutually exclusive"))
+		}
+		if _, err := parseIPPool(c.ProvisionIPPool, c.ProvisionIPPoolRange); err != nil {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("invalid provision_ip_pool: %s", err))
+		}
+	} else if c.ProvisionIPPoolRange != "" {
+		errs = packer.MultiErrorAppend(errs, errors.New("provision_ip_pool_range requires provision_ip_pool"))
 	}
-	if c.ProvisionGatewayIP == "" {
-		errs = packer.MultiErrorAppend(errs, errors.New("provision_gateway_ip must be specified"))
+	staticIP6 := false
+	switch c.ProvisionIP6 {
+	case "", "dhcp", "auto":
+	default:
+		if ip, _, err := net.ParseCIDR(c.ProvisionIP6); err != nil || ip.To4() != nil {
+			errs = packer.MultiErrorAppend(errs, errors.New("provision_ip6 must be dhcp, auto or an IPv6 address with its prefix length"))
+		} else {
+			staticIP6 = true
+		}
+	}
+	if c.ProvisionGatewayIP6 != "" {
+		if !staticIP6 {
+			errs = packer.MultiErrorAppend(errs, errors.New("provision_gateway_ip6 requires a static provision_ip6"))
+		} else if ip := net.ParseIP(c.ProvisionGatewayIP6); ip == nil || ip.To4() != nil {
+			errs = packer.MultiErrorAppend(errs, errors.New("provision_gateway_ip6 must be an IPv6 address"))
+		}
+	}
+	// The pct communicator runs everything through the node, so the container
+	// doesn't need to be reachable
+	if c.Comm.Type != "pct" && c.Comm.Type != "none" {
+		if c.ProvisionIP == "" && c.ProvisionIPPool == "" && !staticIP6 {
+			errs = packer.MultiErrorAppend(errs, errors.New("provision_ip, provision_ip_pool or a static provision_ip6 must be specified"))
+		}
+		if (c.ProvisionIP != "" || c.ProvisionIPPool != "") && c.ProvisionGatewayIP == "" {
+			errs = packer.MultiErrorAppend(errs, errors.New("provision_gateway_ip must be specified"))
+		}
 	}
 
 	// Set internal values
 	//c.Comm.SSHAgentAuth = true
 
-	c.Comm.SSHHost = c.ProvisionIP
+	c.Comm.SSHHost = provisionHost(c)
 
-	errs = packer.MultiErrorAppend(errs, c.Comm.Prepare(&c.ctx)...)
+	if (len(c.FirewallEgressAllow) > 0 || c.FirewallSSHSource != "") && !c.Firewall {
+		errs = packer.MultiErrorAppend(errs, errors.New("firewall_egress_allow and firewall_ssh_source require firewall"))
+	}
+	if c.FirewallSSHSource != "" && net.ParseIP(c.FirewallSSHSource) == nil {
+		if _, _, err := net.ParseCIDR(c.FirewallSSHSource); err != nil {
+			errs = packer.MultiErrorAppend(errs, errors.New("firewall_ssh_source must be an IP address or a CIDR"))
+		}
+	}
+
+	if c.SDNEphemeralVNet {
+		if c.SDNZone == "" {
+			errs = packer.MultiErrorAppend(errs, errors.New("sdn_ephemeral_vnet requires sdn_zone"))
+		}
+		if _, network, err := net.ParseCIDR(c.SDNSubnet); err != nil {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("sdn_ephemeral_vnet requires a valid sdn_subnet: %s", err))
+		} else {
+			c.SDNSubnet = network.String()
+		}
+	} else if c.SDNSubnet != "" {
+		errs = packer.MultiErrorAppend(errs, errors.New("sdn_subnet requires sdn_ephemeral_vnet"))
+	} else if c.SDNZone != "" && c.SDNVNet == "" {
+		errs = packer.MultiErrorAppend(errs, errors.New("sdn_zone requires sdn_vnet or sdn_ephemeral_vnet"))
+	}
+	if len(c.SDNVNet) > 8 {
+		errs = packer.MultiErrorAppend(errs, errors.New("sdn_vnet must not be longer than 8 characters"))
+	}
+
+	if c.Reproducible {
+		c.Repack = true
+		if epoch := os.Getenv("SOURCE_DATE_EPOCH"); c.SourceDateEpoch == 0 && epoch != "" {
+			if c.SourceDateEpoch, err = strconv.ParseInt(epoch, 10, 64); err != nil {
+				errs = packer.MultiErrorAppend(errs, fmt.Errorf("could not parse SOURCE_DATE_EPOCH: %s", err))
+			}
+		}
+	} else if c.SourceDateEpoch != 0 {
+		errs = packer.MultiErrorAppend(errs, errors.New("source_date_epoch requires reproducible"))
+	}
+	if c.Repack {
+		if c.TemplateCompression == "" {
+			c.TemplateCompression = "gzip"
+		}
+		if _, ok := templateCompressions[c.TemplateCompression]; !ok {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("template_compression must be gzip, zstd or xz, not %s", c.TemplateCompression))
+		}
+		for _, pattern := range c.RepackExclude {
+			if _, err := path.Match(pattern, ""); err != nil {
+				errs = packer.MultiErrorAppend(errs, fmt.Errorf("invalid repack_exclude pattern %s: %s", pattern, err))
+			}
+		}
+	} else if len(c.RepackExclude) > 0 || c.TemplateCompression != "" {
+		errs = packer.MultiErrorAppend(errs, errors.New("repack_exclude and template_compression require repack"))
+	}
+
+	for _, name := range c.GeneralizeSkip {
+		found := false
+		for _, item := range generalizeItems {
+			found = found || item.name == name
+		}
+		if !found {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("unknown generalize_skip item %s", name))
+		}
+	}
+
+	if c.ProvisionViaNode {
+		if c.Comm.Type != "" && c.Comm.Type != "ssh" {
+			errs = packer.MultiErrorAppend(errs, errors.New("provision_via_node requires the ssh communicator"))
+		}
+		// The HTTP server is only forwarded once the communicator connects
+		if strings.Contains(c.FlatBootCommand(), ".HTTPIP") {
+			errs = packer.MultiErrorAppend(errs, errors.New("boot_command can't use .HTTPIP with provision_via_node"))
+		}
+		if c.Comm.SSHBastionHost != "" {
+			errs = packer.MultiErrorAppend(errs, errors.New("provision_via_node can't be used with ssh_bastion_host"))
+		} else if user, err := proxmox.NewUserID(c.Username); err != nil {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("could not parse username: %s", err))
+		} else if c.proxmoxURL != nil {
+			// The SDK joins host and port with a colon
+			c.Comm.SSHBastionHost = bracketHost(c.proxmoxURL.Hostname())
+			c.Comm.SSHBastionUsername = user.Name
+			c.Comm.SSHBastionPassword = c.Password
+		}
+	}
+
+	if c.Comm.Type == "pct" {
+		// The SDK doesn't know about pct, which has no settings of its own
+		c.Comm.Type = "none"
+		errs = packer.MultiErrorAppend(errs, c.Comm.Prepare(&c.ctx)...)
+		c.Comm.Type = "pct"
+	} else {
+		errs = packer.MultiErrorAppend(errs, c.Comm.Prepare(&c.ctx)...)
+	}
 	errs = packer.MultiErrorAppend(errs, c.BootConfig.Prepare(&c.ctx)...)
 	errs = packer.MultiErrorAppend(errs, c.HTTPConfig.Prepare(&c.ctx)...)
 
 	if errs != nil && len(errs.Errors) > 0 {
-		return nil, errs
+		return warnings, errs
 	}
 
 	packer.LogSecretFilter.Set(c.Password)
-	return nil, nil
+	if c.S3AccessKey != "" {
+		packer.LogSecretFilter.Set(c.S3AccessKey)
+	}
+	if c.S3SecretKey != "" {
+		packer.LogSecretFilter.Set(c.S3SecretKey)
+	}
+	return warnings, nil
+}
+
+// validProvisionIP reports whether s is an IPv4 address, optionally with its
+// prefix length.
+func validProvisionIP(s string) bool {
+	if !strings.Contains(s, "/") {
+		return net.ParseIP(s).To4() != nil
+	}
+	ip, _, err := net.ParseCIDR(s)
+	return err == nil && ip.To4() != nil
 }
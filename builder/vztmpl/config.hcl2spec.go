@@ -90,6 +90,37 @@ type FlatConfig struct {
 	Unprivileged              *bool             `mapstructure:"unprivileged" cty:"unprivileged" hcl:"unprivileged"`
 	TemplateFile              *string           `mapstructure:"template_file" cty:"template_file" hcl:"template_file"`
 	TemplateSuffix            *string           `mapstructure:"template_suffix" cty:"template_suffix" hcl:"template_suffix"`
+	TemplateName              *string           `mapstructure:"template_name" cty:"template_name" hcl:"template_name"`
+	TemplateApplianceName     *bool             `mapstructure:"template_appliance_name" cty:"template_appliance_name" hcl:"template_appliance_name"`
+	TemplateRelease           *string           `mapstructure:"template_release" cty:"template_release" hcl:"template_release"`
+	OnExistingTemplate        *string           `mapstructure:"on_existing_template" cty:"on_existing_template" hcl:"on_existing_template"`
+	KeepLast                  *int              `mapstructure:"keep_last" cty:"keep_last" hcl:"keep_last"`
+	KeepDays                  *int              `mapstructure:"keep_days" cty:"keep_days" hcl:"keep_days"`
+	RetentionPattern          *string           `mapstructure:"retention_pattern" cty:"retention_pattern" hcl:"retention_pattern"`
+	RetentionDryRun           *bool             `mapstructure:"retention_dry_run" cty:"retention_dry_run" hcl:"retention_dry_run"`
+	DistributeTo              []string          `mapstructure:"distribute_to" cty:"distribute_to" hcl:"distribute_to"`
+	OutputDirectory           *string           `mapstructure:"output_directory" cty:"output_directory" hcl:"output_directory"`
+	SkipUpload                *bool             `mapstructure:"skip_upload" cty:"skip_upload" hcl:"skip_upload"`
+	S3Bucket                  *string           `mapstructure:"s3_bucket" cty:"s3_bucket" hcl:"s3_bucket"`
+	S3Key                     *string           `mapstructure:"s3_key" cty:"s3_key" hcl:"s3_key"`
+	S3Endpoint                *string           `mapstructure:"s3_endpoint" cty:"s3_endpoint" hcl:"s3_endpoint"`
+	S3Region                  *string           `mapstructure:"s3_region" cty:"s3_region" hcl:"s3_region"`
+	S3AccessKey               *string           `mapstructure:"s3_access_key" cty:"s3_access_key" hcl:"s3_access_key"`
+	S3SecretKey               *string           `mapstructure:"s3_secret_key" cty:"s3_secret_key" hcl:"s3_secret_key"`
+	S3ForcePathStyle          *bool             `mapstructure:"s3_force_path_style" cty:"s3_force_path_style" hcl:"s3_force_path_style"`
+	S3ServerSideEncryption    *string           `mapstructure:"s3_server_side_encryption" cty:"s3_server_side_encryption" hcl:"s3_server_side_encryption"`
+	S3KMSKeyID                *string           `mapstructure:"s3_kms_key_id" cty:"s3_kms_key_id" hcl:"s3_kms_key_id"`
+	S3PartSize                *int              `mapstructure:"s3_part_size" cty:"s3_part_size" hcl:"s3_part_size"`
+	TemplateURL               *string           `mapstructure:"template_url" cty:"template_url" hcl:"template_url"`
+	TemplateChecksum          *string           `mapstructure:"template_checksum" cty:"template_checksum" hcl:"template_checksum"`
+	TemplateDownloadTimeout   *string           `mapstructure:"template_download_timeout" cty:"template_download_timeout" hcl:"template_download_timeout"`
+	TemplateOS                *string           `mapstructure:"template_os" cty:"template_os" hcl:"template_os"`
+	TemplateVersion           *string           `mapstructure:"template_version" cty:"template_version" hcl:"template_version"`
+	CloneVMID                 *int              `mapstructure:"clone_vmid" cty:"clone_vmid" hcl:"clone_vmid"`
+	CloneName                 *string           `mapstructure:"clone_name" cty:"clone_name" hcl:"clone_name"`
+	CloneSnapshot             *string           `mapstructure:"clone_snapshot" cty:"clone_snapshot" hcl:"clone_snapshot"`
+	RestoreFromBackup         *string           `mapstructure:"restore_from_backup" cty:"restore_from_backup" hcl:"restore_from_backup"`
+	RestoreFromVMID           *int              `mapstructure:"restore_from_vmid" cty:"restore_from_vmid" hcl:"restore_from_vmid"`
 	TemplateStoragePool       *string           `mapstructure:"template_storage_pool" cty:"template_storage_pool" hcl:"template_storage_pool"`
 	BackupStoragePool         *string           `mapstructure:"backup_storage_pool" cty:"backup_storage_pool" hcl:"backup_storage_pool"`
 	FSStorage                 *string           `mapstructure:"filesystem_storage" cty:"filesystem_storage" hcl:"filesystem_storage"`
@@ -97,7 +128,30 @@ type FlatConfig struct {
 	VMID                      *int              `mapstructure:"vmid" cty:"vmid" hcl:"vmid"`
 	ProvisionIP               *string           `mapstructure:"provision_ip" cty:"provision_ip" hcl:"provision_ip"`
 	ProvisionGatewayIP        *string           `mapstructure:"provision_gateway_ip" cty:"provision_gateway_ip" hcl:"provision_gateway_ip"`
+	ProvisionIPPool           *string           `mapstructure:"provision_ip_pool" cty:"provision_ip_pool" hcl:"provision_ip_pool"`
+	ProvisionIPPoolRange      *string           `mapstructure:"provision_ip_pool_range" cty:"provision_ip_pool_range" hcl:"provision_ip_pool_range"`
+	ProvisionIP6              *string           `mapstructure:"provision_ip6" cty:"provision_ip6" hcl:"provision_ip6"`
+	ProvisionGatewayIP6       *string           `mapstructure:"provision_gateway_ip6" cty:"provision_gateway_ip6" hcl:"provision_gateway_ip6"`
 	ProvisionMac              *string           `mapstructure:"provision_mac" cty:"provision_mac" hcl:"provision_mac"`
+	SDNZone                   *string           `mapstructure:"sdn_zone" cty:"sdn_zone" hcl:"sdn_zone"`
+	SDNVNet                   *string           `mapstructure:"sdn_vnet" cty:"sdn_vnet" hcl:"sdn_vnet"`
+	SDNEphemeralVNet          *bool             `mapstructure:"sdn_ephemeral_vnet" cty:"sdn_ephemeral_vnet" hcl:"sdn_ephemeral_vnet"`
+	SDNSubnet                 *string           `mapstructure:"sdn_subnet" cty:"sdn_subnet" hcl:"sdn_subnet"`
+	ProvisionViaNode          *bool             `mapstructure:"provision_via_node" cty:"provision_via_node" hcl:"provision_via_node"`
+	Firewall                  *bool             `mapstructure:"firewall" cty:"firewall" hcl:"firewall"`
+	FirewallSSHSource         *string           `mapstructure:"firewall_ssh_source" cty:"firewall_ssh_source" hcl:"firewall_ssh_source"`
+	FirewallEgressAllow       []string          `mapstructure:"firewall_egress_allow" cty:"firewall_egress_allow" hcl:"firewall_egress_allow"`
+	SSHBootstrap              *bool             `mapstructure:"ssh_bootstrap" cty:"ssh_bootstrap" hcl:"ssh_bootstrap"`
+	SSHBootstrapRevert        *bool             `mapstructure:"ssh_bootstrap_revert" cty:"ssh_bootstrap_revert" hcl:"ssh_bootstrap_revert"`
+	PinSSHHostKeys            *bool             `mapstructure:"pin_ssh_host_keys" cty:"pin_ssh_host_keys" hcl:"pin_ssh_host_keys"`
+	Generalize                *bool             `mapstructure:"generalize" cty:"generalize" hcl:"generalize"`
+	GeneralizeSkip            []string          `mapstructure:"generalize_skip" cty:"generalize_skip" hcl:"generalize_skip"`
+	GeneralizeCommands        []string          `mapstructure:"generalize_commands" cty:"generalize_commands" hcl:"generalize_commands"`
+	Repack                    *bool             `mapstructure:"repack" cty:"repack" hcl:"repack"`
+	RepackExclude             []string          `mapstructure:"repack_exclude" cty:"repack_exclude" hcl:"repack_exclude"`
+	TemplateCompression       *string           `mapstructure:"template_compression" cty:"template_compression" hcl:"template_compression"`
+	Reproducible              *bool             `mapstructure:"reproducible" cty:"reproducible" hcl:"reproducible"`
+	SourceDateEpoch           *int64            `mapstructure:"source_date_epoch" cty:"source_date_epoch" hcl:"source_date_epoch"`
 }
 
 // FlatMapstructure returns a new FlatConfig.
@@ -192,6 +246,37 @@ func (*FlatConfig) HCL2Spec() map[string]hcldec.Spec {
 		"unprivileged":                 &hcldec.AttrSpec{Name: "unprivileged", Type: cty.Bool, Required: false},
 		"template_file":                &hcldec.AttrSpec{Name: "template_file", Type: cty.String, Required: false},
 		"template_suffix":              &hcldec.AttrSpec{Name: "template_suffix", Type: cty.String, Required: false},
+		"template_name":                &hcldec.AttrSpec{Name: "template_name", Type: cty.String, Required: false},
+		"template_appliance_name":      &hcldec.AttrSpec{Name: "template_appliance_name", Type: cty.Bool, Required: false},
+		"template_release":             &hcldec.AttrSpec{Name: "template_release", Type: cty.String, Required: false},
+		"on_existing_template":         &hcldec.AttrSpec{Name: "on_existing_template", Type: cty.String, Required: false},
+		"keep_last":                    &hcldec.AttrSpec{Name: "keep_last", Type: cty.Number, Required: false},
+		"keep_days":                    &hcldec.AttrSpec{Name: "keep_days", Type: cty.Number, Required: false},
+		"retention_pattern":            &hcldec.AttrSpec{Name: "retention_pattern", Type: cty.String, Required: false},
+		"retention_dry_run":            &hcldec.AttrSpec{Name: "retention_dry_run", Type: cty.Bool, Required: false},
+		"distribute_to":                &hcldec.AttrSpec{Name: "distribute_to", Type: cty.List(cty.String), Required: false},
+		"output_directory":             &hcldec.AttrSpec{Name: "output_directory", Type: cty.String, Required: false},
+		"skip_upload":                  &hcldec.AttrSpec{Name: "skip_upload", Type: cty.Bool, Required: false},
+		"s3_bucket":                    &hcldec.AttrSpec{Name: "s3_bucket", Type: cty.String, Required: false},
+		"s3_key":                       &hcldec.AttrSpec{Name: "s3_key", Type: cty.String, Required: false},
+		"s3_endpoint":                  &hcldec.AttrSpec{Name: "s3_endpoint", Type: cty.String, Required: false},
+		"s3_region":                    &hcldec.AttrSpec{Name: "s3_region", Type: cty.String, Required: false},
+		"s3_access_key":                &hcldec.AttrSpec{Name: "s3_access_key", Type: cty.String, Required: false},
+		"s3_secret_key":                &hcldec.AttrSpec{Name: "s3_secret_key", Type: cty.String, Required: false},
+		"s3_force_path_style":          &hcldec.AttrSpec{Name: "s3_force_path_style", Type: cty.Bool, Required: false},
+		"s3_server_side_encryption":    &hcldec.AttrSpec{Name: "s3_server_side_encryption", Type: cty.String, Required: false},
+		"s3_kms_key_id":                &hcldec.AttrSpec{Name: "s3_kms_key_id", Type: cty.String, Required: false},
+		"s3_part_size":                 &hcldec.AttrSpec{Name: "s3_part_size", Type: cty.Number, Required: false},
+		"template_url":                 &hcldec.AttrSpec{Name: "template_url", Type: cty.String, Required: false},
+		"template_checksum":            &hcldec.AttrSpec{Name: "template_checksum", Type: cty.String, Required: false},
+		"template_download_timeout":    &hcldec.AttrSpec{Name: "template_download_timeout", Type: cty.String, Required: false},
+		"template_os":                  &hcldec.AttrSpec{Name: "template_os", Type: cty.String, Required: false},
+		"template_version":             &hcldec.AttrSpec{Name: "template_version", Type: cty.String, Required: false},
+		"clone_vmid":                   &hcldec.AttrSpec{Name: "clone_vmid", Type: cty.Number, Required: false},
+		"clone_name":                   &hcldec.AttrSpec{Name: "clone_name", Type: cty.String, Required: false},
+		"clone_snapshot":               &hcldec.AttrSpec{Name: "clone_snapshot", Type: cty.String, Required: false},
+		"restore_from_backup":          &hcldec.AttrSpec{Name: "restore_from_backup", Type: cty.String, Required: false},
+		"restore_from_vmid":            &hcldec.AttrSpec{Name: "restore_from_vmid", Type: cty.Number, Required: false},
 		"template_storage_pool":        &hcldec.AttrSpec{Name: "template_storage_pool", Type: cty.String, Required: false},
 		"backup_storage_pool":          &hcldec.AttrSpec{Name: "backup_storage_pool", Type: cty.String, Required: false},
 		"filesystem_storage":           &hcldec.AttrSpec{Name: "filesystem_storage", Type: cty.String, Required: false},
@@ -199,7 +284,30 @@ func (*FlatConfig) HCL2Spec() map[string]hcldec.Spec {
 		"vmid":                         &hcldec.AttrSpec{Name: "vmid", Type: cty.Number, Required: false},
 		"provision_ip":                 &hcldec.AttrSpec{Name: "provision_ip", Type: cty.String, Required: false},
 		"provision_gateway_ip":         &hcldec.AttrSpec{Name: "provision_gateway_ip", Type: cty.String, Required: false},
+		"provision_ip_pool":            &hcldec.AttrSpec{Name: "provision_ip_pool", Type: cty.String, Required: false},
+		"provision_ip_pool_range":      &hcldec.AttrSpec{Name: "provision_ip_pool_range", Type: cty.String, Required: false},
+		"provision_ip6":                &hcldec.AttrSpec{Name: "provision_ip6", Type: cty.String, Required: false},
+		"provision_gateway_ip6":        &hcldec.AttrSpec{Name: "provision_gateway_ip6", Type: cty.String, Required: false},
 		"provision_mac":                &hcldec.AttrSpec{Name: "provision_mac", Type: cty.String, Required: false},
+		"sdn_zone":                     &hcldec.AttrSpec{Name: "sdn_zone", Type: cty.String, Required: false},
+		"sdn_vnet":                     &hcldec.AttrSpec{Name: "sdn_vnet", Type: cty.String, Required: false},
+		"sdn_ephemeral_vnet":           &hcldec.AttrSpec{Name: "sdn_ephemeral_vnet", Type: cty.Bool, Required: false},
+		"sdn_subnet":                   &hcldec.AttrSpec{Name: "sdn_subnet", Type: cty.String, Required: false},
+		"provision_via_node":           &hcldec.AttrSpec{Name: "provision_via_node", Type: cty.Bool, Required: false},
+		"firewall":                     &hcldec.AttrSpec{Name: "firewall", Type: cty.Bool, Required: false},
+		"firewall_ssh_source":          &hcldec.AttrSpec{Name: "firewall_ssh_source", Type: cty.String, Required: false},
+		"firewall_egress_allow":        &hcldec.AttrSpec{Name: "firewall_egress_allow", Type: cty.List(cty.String), Required: false},
+		"ssh_bootstrap":                &hcldec.AttrSpec{Name: "ssh_bootstrap", Type: cty.Bool, Required: false},
+		"ssh_bootstrap_revert":         &hcldec.AttrSpec{Name: "ssh_bootstrap_revert", Type: cty.Bool, Required: false},
+		"pin_ssh_host_keys":            &hcldec.AttrSpec{Name: "pin_ssh_host_keys", Type: cty.Bool, Required: false},
+		"generalize":                   &hcldec.AttrSpec{Name: "generalize", Type: cty.Bool, Required: false},
+		"generalize_skip":              &hcldec.AttrSpec{Name: "generalize_skip", Type: cty.List(cty.String), Required: false},
+		"generalize_commands":          &hcldec.AttrSpec{Name: "generalize_commands", Type: cty.List(cty.String), Required: false},
+		"repack":                       &hcldec.AttrSpec{Name: "repack", Type: cty.Bool, Required: false},
+		"repack_exclude":               &hcldec.AttrSpec{Name: "repack_exclude", Type: cty.List(cty.String), Required: false},
+		"template_compression":         &hcldec.AttrSpec{Name: "template_compression", Type: cty.String, Required: false},
+		"reproducible":                 &hcldec.AttrSpec{Name: "reproducible", Type: cty.Bool, Required: false},
+		"source_date_epoch":            &hcldec.AttrSpec{Name: "source_date_epoch", Type: cty.Number, Required: false},
 	}
 	return s
 }
@@ -0,0 +1,67 @@
+package vztmpl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testConfig() map[string]interface{} {
+	return map[string]interface{}{
+		"proxmox_url":          "https://pve.example.com:8006/api2/json",
+		"username":             "root@pam",
+		"password":             "secret",
+		"node":                 "pve",
+		"template_file":        "debian-12-standard_12.2-1_amd64.tar.zst",
+		"filesystem_storage":   "local-lvm",
+		"filesystem_size":      8,
+		"template_suffix":      "custom",
+		"provision_gateway_ip": "10.0.0.1",
+		"ssh_username":         "root",
+		"ssh_password":         "secret",
+	}
+}
+
+func TestPrepareProvisionIP(t *testing.T) {
+	tests := []struct {
+		provisionIP string
+		valid       bool
+	}{
+		{"10.0.0.5", true},
+		{"10.0.0.5/24", true},
+		{"10.0.0", false},
+		{"pve.example.com", false},
+		{"fd00::5", false},
+		{"10.0.0.5/33", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.provisionIP, func(t *testing.T) {
+			raw := testConfig()
+			raw["provision_ip"] = tt.provisionIP
+
+			var c Config
+			_, err := c.Prepare(raw)
+			if tt.valid {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, "provision_ip must be an IPv4 address")
+			}
+		})
+	}
+}
+
+func TestPrepareProvisionViaNodeBootCommand(t *testing.T) {
+	raw := testConfig()
+	raw["provision_ip"] = "10.0.0.5"
+	raw["provision_via_node"] = true
+	raw["boot_command"] = []string{"wget http://{{ .HTTPIP }}:{{ .HTTPPort }}/setup.sh<enter>"}
+
+	var c Config
+	_, err := c.Prepare(raw)
+	require.ErrorContains(t, err, "boot_command can't use .HTTPIP with provision_via_node")
+
+	raw["boot_command"] = []string{"apt-get update<enter>"}
+	c = Config{}
+	_, err = c.Prepare(raw)
+	require.NoError(t, err)
+}
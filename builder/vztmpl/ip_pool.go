@@ -0,0 +1,108 @@
+package vztmpl
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ipPool is a range of IPv4 addresses of a network, from which provisioning
+// addresses are leased.
+type ipPool struct {
+	network *net.IPNet
+	first   uint32
+	last    uint32
+}
+
+// parseIPPool parses provision_ip_pool and provision_ip_pool_range. Without
+// range, the pool holds all the host addresses of the network.
+func parseIPPool(cidr string, rng string) (*ipPool, error) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	base := network.IP.To4()
+	if base == nil {
+		return nil, fmt.Errorf("%s is not an IPv4 network", cidr)
+	}
+	ones, bits := network.Mask.Size()
+	if bits-ones < 2 {
+		return nil, fmt.Errorf("%s has no host addresses", cidr)
+	}
+
+	pool := &ipPool{network: network}
+	pool.first = binary.BigEndian.Uint32(base) + 1
+	pool.last = binary.BigEndian.Uint32(base) | (1<<uint(bits-ones) - 1) - 1
+
+	if rng != "" {
+		bounds := strings.SplitN(rng, "-", 2)
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("range %s is not of the form first-last", rng)
+		}
+		var ips [2]uint32
+		for i, bound := range bounds {
+			ip := net.ParseIP(strings.TrimSpace(bound)).To4()
+			if ip == nil || !network.Contains(ip) {
+				return nil, fmt.Errorf("%s is not an address of %s", bound, cidr)
+			}
+			ips[i] = binary.BigEndian.Uint32(ip)
+		}
+		if ips[0] > ips[1] {
+			return nil, fmt.Errorf("range %s is empty", rng)
+		}
+		pool.first, pool.last = ips[0], ips[1]
+	}
+	return pool, nil
+}
+
+// prefixLength returns the prefix length of the pool network.
+func (p *ipPool) prefixLength() int {
+	ones, _ := p.network.Mask.Size()
+	return ones
+}
+
+// free returns the addresses of the pool that are not in used, in order.
+func (p *ipPool) free(used map[string]bool) []string {
+	var addresses []string
+	for n := p.first; n <= p.last && n >= p.first; n++ {
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, n)
+		if !used[ip.String()] {
+			addresses = append(addresses, ip.String())
+		}
+	}
+	return addresses
+}
+
+// configAddresses returns the static IPv4 addresses found in the network
+// interfaces and cloud-init settings of a container or VM configuration.
+func configAddresses(config map[string]interface{}) []string {
+	var addresses []string
+	for key, value := range config {
+		if !strings.HasPrefix(key, "net") && !strings.HasPrefix(key, "ipconfig") {
+			continue
+		}
+		device, ok := value.(string)
+		if !ok {
+			continue
+		}
+		for _, param := range strings.Split(device, ",") {
+			if !strings.HasPrefix(param, "ip=") {
+				continue
+			}
+			ip := strings.SplitN(strings.TrimPrefix(param, "ip="), "/", 2)[0]
+			if net.ParseIP(ip) != nil {
+				addresses = append(addresses, ip)
+			}
+		}
+	}
+	return addresses
+}
+
+// poolMac derives a locally administered MAC address from a leased address,
+// so that concurrent builds on the same bridge don't collide.
+func poolMac(ip string) string {
+	b := net.ParseIP(ip).To4()
+	return fmt.Sprintf("1e:eb:%02x:%02x:%02x:%02x", b[0], b[1], b[2], b[3])
+}
@@ -0,0 +1,43 @@
+package vztmpl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseIPPool(t *testing.T) {
+	pool, err := parseIPPool("10.0.0.0/29", "")
+	require.NoError(t, err)
+	require.Equal(t, 29, pool.prefixLength())
+	require.Equal(t, []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.5", "10.0.0.6"}, pool.free(nil))
+
+	pool, err = parseIPPool("10.0.0.0/24", "10.0.0.100-10.0.0.103")
+	require.NoError(t, err)
+	used := map[string]bool{"10.0.0.101": true}
+	require.Equal(t, []string{"10.0.0.100", "10.0.0.102", "10.0.0.103"}, pool.free(used))
+
+	_, err = parseIPPool("10.0.0.0/24", "10.0.1.1-10.0.1.5")
+	require.Error(t, err)
+	_, err = parseIPPool("10.0.0.0/24", "10.0.0.5-10.0.0.1")
+	require.Error(t, err)
+	_, err = parseIPPool("10.0.0.0/31", "")
+	require.Error(t, err)
+	_, err = parseIPPool("fd00::/64", "")
+	require.Error(t, err)
+}
+
+func TestConfigAddresses(t *testing.T) {
+	config := map[string]interface{}{
+		"net0":      "name=eth0,bridge=vmbr0,hwaddr=1E:EB:08:D1:E7:E2,ip=10.0.0.5/24,gw=10.0.0.1",
+		"net1":      "name=eth1,bridge=vmbr1,ip=dhcp",
+		"ipconfig0": "ip=10.0.0.6/24,gw=10.0.0.1",
+		"hostname":  "ip=10.0.0.7",
+		"memory":    float64(512),
+	}
+	require.ElementsMatch(t, []string{"10.0.0.5", "10.0.0.6"}, configAddresses(config))
+}
+
+func TestPoolMac(t *testing.T) {
+	require.Equal(t, "1e:eb:0a:00:00:64", poolMac("10.0.0.100"))
+}
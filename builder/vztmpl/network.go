@@ -0,0 +1,52 @@
+package vztmpl
+
+import (
+	"net"
+	"strings"
+)
+
+// provisionHost returns the address the communicator connects to in the
+// container, preferring IPv4 when both provision_ip and a static
+// provision_ip6 are set.
+func provisionHost(c *Config) string {
+	if c.ProvisionIP != "" {
+		return strings.SplitN(c.ProvisionIP, "/", 2)[0]
+	}
+	if ip, _, err := net.ParseCIDR(c.ProvisionIP6); err == nil {
+		return ip.String()
+	}
+	return ""
+}
+
+// bracketHost encloses IPv6 literals in brackets, for use in host:port
+// addresses built by string formatting.
+func bracketHost(host string) string {
+	if strings.Contains(host, ":") && !strings.HasPrefix(host, "[") {
+		return "[" + host + "]"
+	}
+	return host
+}
+
+// httpServerIP returns the address of the Packer host HTTP server as seen from
+// the container: http_bind_address when set, otherwise the local address used
+// to reach the container, or the Proxmox node.
+func httpServerIP(c *Config) (string, error) {
+	if c.HTTPAddress != "" && c.HTTPAddress != "0.0.0.0" && c.HTTPAddress != "::" {
+		return c.HTTPAddress, nil
+	}
+	target := provisionHost(c)
+	if target == "" {
+		target = c.proxmoxURL.Hostname()
+	}
+	return localIPFor(target)
+}
+
+// localIPFor returns the local address the Packer host uses to reach host.
+func localIPFor(host string) (string, error) {
+	conn, err := net.Dial("udp", net.JoinHostPort(host, "80"))
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
@@ -0,0 +1,75 @@
+package vztmpl
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/Telmate/proxmox-api-go/proxmox"
+	"golang.org/x/crypto/ssh"
+)
+
+// newNodeSSHClient opens an SSH connection to the Proxmox node using the same
+// credentials as the API.
+func newNodeSSHClient(c *Config) (*ssh.Client, error) {
+	user, err := proxmox.NewUserID(c.Username)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing username: %s", err)
+	}
+
+	config := &ssh.ClientConfig{
+		User: user.Name,
+		Auth: []ssh.AuthMethod{
+			ssh.Password(c.Password),
+		},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+
+	client, err := ssh.Dial("tcp", net.JoinHostPort(c.proxmoxURL.Hostname(), "22"), config)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to node over SSH: %s", err)
+	}
+	return client, nil
+}
+
+// runNodeCommand runs cmd on the node and returns its standard output.
+func runNodeCommand(client *ssh.Client, cmd string) (string, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+	if err := session.Run(cmd); err != nil {
+		return "", fmt.Errorf("%s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// storageVolumePath returns the path of a storage volume on the node file system.
+func storageVolumePath(client *proxmox.Client, node string, storage string, volid string) (string, error) {
+	url := fmt.Sprintf("/nodes/%s/storage/%s/content/%s", node, storage, volid)
+	detail, err := client.GetItemConfigMapStringInterface(url, "list_storage", "STORAGE")
+	if err != nil {
+		return "", err
+	}
+	path, _ := detail["path"].(string)
+	if path == "" {
+		return "", fmt.Errorf("could not find path of volume %s", volid)
+	}
+	return path, nil
+}
+
+// shellQuote quotes s for use as a single word in a POSIX shell command line.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
+
+// runContainerCommand runs script with sh inside the container through pct exec.
+func runContainerCommand(client *ssh.Client, vmid int, script string) (string, error) {
+	return runNodeCommand(client, fmt.Sprintf("pct exec %d -- sh -c %s", vmid, shellQuote(script)))
+}
@@ -0,0 +1,271 @@
+package vztmpl
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"golang.org/x/crypto/ssh"
+)
+
+// sshBootstrapCommands holds the distribution specific commands used to set up,
+// and later remove, an SSH server in the container.
+type sshBootstrapCommands struct {
+	install string
+	enabled string
+	enable  string
+	disable string
+	remove  string
+}
+
+var sshBootstrapDistros = map[string]sshBootstrapCommands{
+	"alpine": {
+		install: "apk add --no-cache openssh",
+		enabled: "rc-update show default | grep -qw sshd",
+		enable:  "rc-update add sshd default && rc-service sshd restart",
+		disable: "rc-service sshd stop; rc-update del sshd default",
+		remove:  "rc-service sshd stop; rc-update del sshd default; apk del openssh",
+	},
+	"debian": {
+		install: "apt-get update && DEBIAN_FRONTEND=noninteractive apt-get install -y openssh-server",
+		enabled: "systemctl is-enabled -q ssh",
+		enable:  "systemctl enable ssh && systemctl restart ssh",
+		disable: "systemctl disable --now ssh",
+		remove:  "systemctl disable --now ssh; DEBIAN_FRONTEND=noninteractive apt-get purge -y openssh-server && apt-get autoremove -y",
+	},
+	"fedora": {
+		install: "dnf install -y openssh-server",
+		enabled: "systemctl is-enabled -q sshd",
+		enable:  "systemctl enable sshd && systemctl restart sshd",
+		disable: "systemctl disable --now sshd",
+		remove:  "systemctl disable --now sshd; dnf remove -y openssh-server",
+	},
+	"arch": {
+		install: "pacman -Sy --noconfirm openssh",
+		enabled: "systemctl is-enabled -q sshd",
+		enable:  "systemctl enable sshd && systemctl restart sshd",
+		disable: "systemctl disable --now sshd",
+		remove:  "systemctl disable --now sshd; pacman -Rns --noconfirm openssh",
+	},
+	"suse": {
+		install: "zypper --non-interactive install openssh-server",
+		enabled: "systemctl is-enabled -q sshd",
+		enable:  "systemctl enable sshd && systemctl restart sshd",
+		disable: "systemctl disable --now sshd",
+		remove:  "systemctl disable --now sshd; zypper --non-interactive remove openssh-server",
+	},
+}
+
+const sshdConfigBackup = "/etc/ssh/sshd_config.packer-bootstrap"
+
+// sshBootstrap records what stepBootstrapSSH changed, so that it can be reverted.
+type sshBootstrap struct {
+	distro    string
+	installed bool
+	// Whether the SSH server was already enabled at boot
+	enabled bool
+	// Host keys generated by ssh-keygen -A
+	hostKeys []string
+}
+
+// listHostKeysScript prints the private host keys of the container, one per line.
+const listHostKeysScript = `for f in /etc/ssh/ssh_host_*_key; do test -e "$f" && echo "$f"; done; true`
+
+// stepBootstrapSSH installs and starts an SSH server allowing root key logins in
+// minimal base templates, through pct exec, before the communicator connects.
+//
+// It sets the sshBootstrap state used by stepRevertSSHBootstrap.
+type stepBootstrapSSH struct{}
+
+func (s *stepBootstrapSSH) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	c := state.Get("config").(*Config)
+
+	if !c.SSHBootstrap {
+		return multistep.ActionContinue
+	}
+
+	ui.Say("Bootstrapping SSH server in LXC Container")
+	sshClient, err := newNodeSSHClient(c)
+	if err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	defer sshClient.Close()
+
+	bootstrap, err := bootstrapSSH(ctx, ui, sshClient, c.VMID)
+	if bootstrap != nil {
+		state.Put("sshBootstrap", bootstrap)
+	}
+	if err != nil {
+		err := fmt.Errorf("error bootstrapping SSH server: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	return multistep.ActionContinue
+}
+
+func (s *stepBootstrapSSH) Cleanup(state multistep.StateBag) {}
+
+func bootstrapSSH(ctx context.Context, ui packersdk.Ui, client *ssh.Client, vmid int) (*sshBootstrap, error) {
+	// The container may still be booting, give pct exec a few chances
+	var osRelease string
+	var err error
+	for n := 0; n < 10; n++ {
+		osRelease, err = runContainerCommand(client, vmid, `. /etc/os-release && echo "$ID $ID_LIKE"`)
+		if err == nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(3 * time.Second):
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not detect distribution: %s", err)
+	}
+
+	distro := detectDistro(osRelease)
+	commands, ok := sshBootstrapDistros[distro]
+	if !ok {
+		return nil, fmt.Errorf("unsupported distribution %q", strings.TrimSpace(osRelease))
+	}
+	bootstrap := &sshBootstrap{distro: distro}
+
+	if _, err := runContainerCommand(client, vmid, "command -v sshd || test -x /usr/sbin/sshd"); err != nil {
+		ui.Message(fmt.Sprintf("Installing SSH server (%s)", distro))
+		// The network may not be up yet right after start
+		for n := 0; n < 5; n++ {
+			_, err = runContainerCommand(client, vmid, commands.install)
+			if err == nil {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				return bootstrap, ctx.Err()
+			case <-time.After(5 * time.Second):
+			}
+		}
+		if err != nil {
+			return bootstrap, err
+		}
+		bootstrap.installed = true
+	}
+
+	if !bootstrap.installed {
+		_, err := runContainerCommand(client, vmid, commands.enabled)
+		bootstrap.enabled = err == nil
+	}
+	keysBefore, err := runContainerCommand(client, vmid, listHostKeysScript)
+	if err != nil {
+		return bootstrap, fmt.Errorf("could not list host keys: %s", err)
+	}
+
+	ui.Message("Allowing root login with keys and starting SSH server")
+	script := fmt.Sprintf(`test -e %[1]s || cp /etc/ssh/sshd_config %[1]s; `+
+		`if grep -q '^#\?PermitRootLogin' /etc/ssh/sshd_config; then `+
+		`sed -i 's/^#\?PermitRootLogin.*/PermitRootLogin prohibit-password/' /etc/ssh/sshd_config; `+
+		`else echo 'PermitRootLogin prohibit-password' >> /etc/ssh/sshd_config; fi; `+
+		`ssh-keygen -A`, sshdConfigBackup)
+	if _, err := runContainerCommand(client, vmid, script); err != nil {
+		return bootstrap, err
+	}
+	keysAfter, err := runContainerCommand(client, vmid, listHostKeysScript)
+	if err != nil {
+		return bootstrap, fmt.Errorf("could not list host keys: %s", err)
+	}
+	bootstrap.hostKeys = newHostKeys(keysBefore, keysAfter)
+	if _, err := runContainerCommand(client, vmid, commands.enable); err != nil {
+		return bootstrap, err
+	}
+
+	return bootstrap, nil
+}
+
+// newHostKeys returns the host keys listed in after but not in before.
+func newHostKeys(before string, after string) []string {
+	existing := make(map[string]bool)
+	for _, key := range strings.Fields(before) {
+		existing[key] = true
+	}
+	var keys []string
+	for _, key := range strings.Fields(after) {
+		if !existing[key] {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// detectDistro maps the ID and ID_LIKE fields of /etc/os-release to one of the
+// distribution families in sshBootstrapDistros.
+func detectDistro(osRelease string) string {
+	for _, id := range strings.Fields(osRelease) {
+		switch id {
+		case "alpine":
+			return "alpine"
+		case "debian", "ubuntu", "devuan":
+			return "debian"
+		case "fedora", "rhel", "centos", "rocky", "almalinux":
+			return "fedora"
+		case "arch", "archlinux":
+			return "arch"
+		case "suse", "opensuse", "opensuse-leap", "opensuse-tumbleweed":
+			return "suse"
+		}
+	}
+	return ""
+}
+
+// stepRevertSSHBootstrap undoes the changes of stepBootstrapSSH before the
+// container is exported, when ssh_bootstrap_revert is set.
+type stepRevertSSHBootstrap struct{}
+
+func (s *stepRevertSSHBootstrap) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	c := state.Get("config").(*Config)
+
+	bootstrapUntyped, ok := state.GetOk("sshBootstrap")
+	if !ok || !c.SSHBootstrapRevert {
+		return multistep.ActionContinue
+	}
+	bootstrap := bootstrapUntyped.(*sshBootstrap)
+
+	ui.Say("Reverting SSH server bootstrap in LXC Container")
+	sshClient, err := newNodeSSHClient(c)
+	if err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	defer sshClient.Close()
+
+	commands := sshBootstrapDistros[bootstrap.distro]
+	script := fmt.Sprintf("if test -e %[1]s; then mv %[1]s /etc/ssh/sshd_config; fi", sshdConfigBackup)
+	if bootstrap.installed {
+		script = fmt.Sprintf("%s; rm -f %s", commands.remove, sshdConfigBackup)
+	} else if !bootstrap.enabled {
+		script = fmt.Sprintf("%s; %s", commands.disable, script)
+	}
+	// Generated host keys would be shared by every container of the template
+	for _, key := range bootstrap.hostKeys {
+		script += fmt.Sprintf("; rm -f %[1]s %[1]s.pub", shellQuote(key))
+	}
+	if _, err := runContainerCommand(sshClient, c.VMID, script); err != nil {
+		err := fmt.Errorf("error reverting SSH server bootstrap: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	return multistep.ActionContinue
+}
+
+func (s *stepRevertSSHBootstrap) Cleanup(state multistep.StateBag) {}
@@ -0,0 +1,14 @@
+package vztmpl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewHostKeys(t *testing.T) {
+	before := "/etc/ssh/ssh_host_rsa_key\n"
+	after := "/etc/ssh/ssh_host_ecdsa_key\n/etc/ssh/ssh_host_ed25519_key\n/etc/ssh/ssh_host_rsa_key\n"
+	require.Equal(t, []string{"/etc/ssh/ssh_host_ecdsa_key", "/etc/ssh/ssh_host_ed25519_key"}, newHostKeys(before, after))
+	require.Empty(t, newHostKeys(after, after))
+}
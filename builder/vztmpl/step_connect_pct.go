@@ -0,0 +1,54 @@
+package vztmpl
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Telmate/proxmox-api-go/proxmox"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// stepConnectPct sets up the pct communicator, used when communicator is "pct".
+//
+// It sets the communicator state used by the provisioners.
+type stepConnectPct struct {
+	comm *pctCommunicator
+}
+
+func (s *stepConnectPct) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	c := state.Get("config").(*Config)
+	vmRef := state.Get("vmRef").(*proxmox.VmRef)
+
+	if s.comm != nil {
+		// Already connected, when running again after pause_before_connecting
+		return multistep.ActionContinue
+	}
+
+	ui.Say(fmt.Sprintf("Connecting to LXC Container %d through pct on the node...", vmRef.VmId()))
+	sshClient, err := newNodeSSHClient(c)
+	if err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	s.comm, err = newPctCommunicator(sshClient, vmRef.VmId())
+	if err != nil {
+		sshClient.Close()
+		err := fmt.Errorf("error starting SFTP session on node: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	state.Put("communicator", s.comm)
+	return multistep.ActionContinue
+}
+
+func (s *stepConnectPct) Cleanup(state multistep.StateBag) {
+	if s.comm != nil {
+		s.comm.Close()
+	}
+}
@@ -0,0 +1,56 @@
+package vztmpl
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Telmate/proxmox-api-go/proxmox"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// stepDebugSummary prints how to get into the container and the node when
+// running with -debug.
+type stepDebugSummary struct {
+	DebugKeyPath string
+}
+
+func (s *stepDebugSummary) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	c := state.Get("config").(*Config)
+	vmRef := state.Get("vmRef").(*proxmox.VmRef)
+
+	if !c.PackerDebug {
+		return multistep.ActionContinue
+	}
+
+	user, err := proxmox.NewUserID(c.Username)
+	if err != nil {
+		err := fmt.Errorf("error parsing username: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	ui.Say("Debug mode: connection details")
+	if c.Comm.Type == "ssh" && c.Comm.SSHHost != "" {
+		keyPath := c.Comm.SSHPrivateKeyFile
+		if keyPath == "" && c.Comm.SSHPassword == "" && !c.Comm.SSHAgentAuth {
+			keyPath = s.DebugKeyPath
+		}
+		command := "ssh"
+		if keyPath != "" {
+			command += " -i " + keyPath
+		}
+		if c.ProvisionViaNode {
+			command += fmt.Sprintf(" -J %s@%s", user.Name, c.proxmoxURL.Hostname())
+		}
+		command += fmt.Sprintf(" -p %d %s@%s", c.Comm.SSHPort, c.Comm.SSHUsername, c.Comm.SSHHost)
+		ui.Message(fmt.Sprintf("Container: %s", command))
+	}
+	ui.Message(fmt.Sprintf("Node: ssh %s@%s, then run: pct enter %d", user.Name, c.proxmoxURL.Hostname(), vmRef.VmId()))
+
+	return multistep.ActionContinue
+}
+
+func (s *stepDebugSummary) Cleanup(state multistep.StateBag) {}
@@ -0,0 +1,178 @@
+package vztmpl
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/Telmate/proxmox-api-go/proxmox"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// stepDistributeTemplate uploads the saved template archive to each of the
+// distribute_to storages in parallel, checking its size once stored. When any
+// of them fails, the copies already made are deleted.
+//
+// It sets the templateVolids state, listing the volume IDs of every copy of the
+// template prefixed with their node, the one of template_storage_pool first.
+type stepDistributeTemplate struct{}
+
+func (s *stepDistributeTemplate) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	c := state.Get("config").(*Config)
+	client := state.Get("proxmoxClient").(*proxmox.Client)
+
+	if c.SkipUpload {
+		return multistep.ActionContinue
+	}
+
+	name := state.Get("templatePath").(string)
+	volids := []string{distributeTarget{Node: c.Node, Storage: c.TemplateStoragePool}.volid(name)}
+	if len(c.distributeTargets) == 0 {
+		state.Put("templateVolids", volids)
+		return multistep.ActionContinue
+	}
+
+	archivePath := state.Get("templateArchive").(string)
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	ui.Say(fmt.Sprintf("Distributing template %s to %d storages", name, len(c.distributeTargets)))
+	errs := make([]error, len(c.distributeTargets))
+	var wg sync.WaitGroup
+	for i, target := range c.distributeTargets {
+		wg.Add(1)
+		go func(i int, target distributeTarget) {
+			defer wg.Done()
+			errs[i] = distributeTemplate(client, target, archivePath, name, info.Size())
+			if errs[i] != nil {
+				ui.Error(fmt.Sprintf("%s: %s", target, errs[i]))
+				return
+			}
+			ui.Message(fmt.Sprintf("%s: done", target))
+		}(i, target)
+	}
+	wg.Wait()
+
+	failed := 0
+	for i := range c.distributeTargets {
+		if errs[i] != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		// Don't leave a template on some of the storages only
+		for i, target := range c.distributeTargets {
+			if errs[i] != nil {
+				continue
+			}
+			ui.Message(fmt.Sprintf("%s: deleting template", target))
+			if err := deleteTemplate(client, target, name); err != nil {
+				ui.Error(fmt.Sprintf("Error deleting template %s. Please delete it manually: %s", target.volid(name), err))
+			}
+		}
+		state.Put("templateVolids", volids)
+		err := fmt.Errorf("error distributing template: %d of %d storages failed", failed, len(c.distributeTargets))
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	for _, target := range c.distributeTargets {
+		volids = append(volids, target.volid(name))
+		if c.OnExistingTemplate != "overwrite" {
+			continue
+		}
+		stale, err := staleTemplateFiles(client, target, state.Get("templateName").(string), name)
+		if err != nil {
+			ui.Error(fmt.Sprintf("Error listing overwritten templates of %s: %s", target, err))
+		}
+		for _, f := range stale {
+			ui.Message(fmt.Sprintf("Deleting overwritten template %s", target.volid(f)))
+			if err := deleteTemplate(client, target, f); err != nil {
+				ui.Error(fmt.Sprintf("Error deleting template %s. Please delete it manually: %s", target.volid(f), err))
+			}
+		}
+	}
+	state.Put("templateVolids", volids)
+
+	return multistep.ActionContinue
+}
+
+func (s *stepDistributeTemplate) Cleanup(state multistep.StateBag) {}
+
+// distributeTemplate uploads the archive at archivePath to a target storage
+// as name, replacing any template with that name, and checks the size of the
+// stored template.
+func distributeTemplate(client *proxmox.Client, target distributeTarget, archivePath string, name string, size int64) error {
+	r, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if err := client.Upload(target.Node, target.Storage, "vztmpl", name, r); err != nil {
+		return err
+	}
+
+	files, err := proxmox.ListFiles(client, target.Node, target.Storage, proxmox.ContentType_Template)
+	if err != nil {
+		return err
+	}
+	for _, f := range *files {
+		if f.Name != name {
+			continue
+		}
+		if int64(f.Size) != size {
+			deleteTemplate(client, target, name)
+			return fmt.Errorf("stored template size %d doesn't match the uploaded %d", f.Size, size)
+		}
+		return nil
+	}
+	return fmt.Errorf("template not found after upload")
+}
+
+// stepCheckDistributeTargets checks, before the build, that the distribute_to
+// storages exist and that no two of them are the same shared storage, which
+// would be uploaded to concurrently.
+type stepCheckDistributeTargets struct{}
+
+func (s *stepCheckDistributeTargets) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	c := state.Get("config").(*Config)
+	client := state.Get("proxmoxClient").(*proxmox.Client)
+
+	if len(c.distributeTargets) == 0 {
+		return multistep.ActionContinue
+	}
+
+	primary := distributeTarget{Node: c.Node, Storage: c.TemplateStoragePool}
+	shared := make(map[distributeTarget]bool)
+	for _, t := range append([]distributeTarget{primary}, c.distributeTargets...) {
+		url := fmt.Sprintf("/nodes/%s/storage/%s/status", t.Node, t.Storage)
+		status, err := client.GetItemConfigMapStringInterface(url, "storage", "STATUS")
+		if err != nil {
+			err := fmt.Errorf("error reading status of storage %s: %s", t, err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		isShared, _ := status["shared"].(float64)
+		shared[t] = isShared == 1
+	}
+	if err := checkDistributeTargets(primary, c.distributeTargets, shared); err != nil {
+		err := fmt.Errorf("invalid distribute_to: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	return multistep.ActionContinue
+}
+
+func (s *stepCheckDistributeTargets) Cleanup(state multistep.StateBag) {}
@@ -0,0 +1,120 @@
+package vztmpl
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/Telmate/proxmox-api-go/proxmox"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// stepDownloadBackup transfers the backup made by stepConvertToBackup from the
+// node to a local file, and deletes it from the backup storage.
+//
+// It sets the templateArchive state holding the path of the local archive.
+type stepDownloadBackup struct{}
+
+func (s *stepDownloadBackup) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	c := state.Get("config").(*Config)
+
+	backupSrcPath := state.Get("backupSrcPath").(string)
+
+	user, err := proxmox.NewUserID(c.Username)
+	if err != nil {
+		err := fmt.Errorf("error parsing username: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	ui.Say(fmt.Sprintf("Establishing SSH connection at [%s] to get backup...", c.proxmoxURL.Hostname()))
+	SftpClient, err := ConnectSFTP(ui, user.Name, c.Password, c.proxmoxURL.Hostname(), 22)
+	if err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	defer SftpClient.Close()
+	ui.Say(fmt.Sprintf("Establishing SSH connection at [%s] to get backup...Done", c.proxmoxURL.Hostname()))
+
+	archivePath, err := downloadBackup(ui, SftpClient, backupSrcPath)
+	if err != nil {
+		err := fmt.Errorf("error downloading backup: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	state.Put("templateArchive", archivePath)
+
+	ui.Say("Finished. Deleting Backup File")
+	err = SftpClient.Remove(backupSrcPath)
+	if err != nil {
+		ui.Error(fmt.Sprintf("Error Backup. Please delete it manually: %s", err))
+	}
+	ui.Say("Finished. Deleting Backup File...Done")
+
+	return multistep.ActionContinue
+}
+
+func (s *stepDownloadBackup) Cleanup(state multistep.StateBag) {
+	if archivePath, ok := state.GetOk("templateArchive"); ok {
+		os.Remove(archivePath.(string))
+	}
+}
+
+func ConnectSFTP(ui packersdk.Ui, apiUser string, apiPassword string, apiAddr string, apiPort int) (*sftp.Client, error) {
+
+	config := &ssh.ClientConfig{
+		User: apiUser,
+		Auth: []ssh.AuthMethod{
+			ssh.Password(apiPassword),
+		},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+
+	var sshAddr string = net.JoinHostPort(apiAddr, strconv.Itoa(apiPort))
+
+	client, err := ssh.Dial("tcp", sshAddr, config)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to node over SSH: %s", err)
+	}
+	ftpClient, err := sftp.NewClient(client)
+	if err != nil {
+		return nil, err
+	}
+
+	return ftpClient, nil
+}
+
+// downloadBackup copies the backup at srcFilePath on the node to a local
+// temporary file, and returns its path.
+func downloadBackup(ui packersdk.Ui, ftpClient *sftp.Client, srcFilePath string) (string, error) {
+	ui.Say(fmt.Sprintf("Opening vzdump template backup %s ...", srcFilePath))
+	srcFile, err := ftpClient.Open(srcFilePath)
+	if err != nil {
+		return "", err
+	}
+	defer srcFile.Close()
+
+	ui.Say("Creating temp file to store backup file ...")
+	dstFile, err := os.CreateTemp("", "vztmpl")
+	if err != nil {
+		return "", err
+	}
+	defer dstFile.Close()
+
+	ui.Say("Transferring vzdump data template to local path...")
+	if _, err := dstFile.ReadFrom(srcFile); err != nil {
+		os.Remove(dstFile.Name())
+		return "", err
+	}
+
+	return dstFile.Name(), nil
+}
@@ -0,0 +1,253 @@
+package vztmpl
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Telmate/proxmox-api-go/proxmox"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// stepDownloadTemplate makes sure the base template is present in the template
+// storage before the container gets created.
+//
+// When template_url is set and template_file can't be found in the storage, the
+// node is asked to fetch it through the download-url API. If the API is not
+// available or not permitted, or the node is unable to reach the URL, the
+// template is downloaded on the Packer host and uploaded instead.
+type stepDownloadTemplate struct{}
+
+func (s *stepDownloadTemplate) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	c := state.Get("config").(*Config)
+	client := state.Get("proxmoxClient").(*proxmox.Client)
+
+	if c.TemplateURL == "" {
+		return multistep.ActionContinue
+	}
+
+	files, err := proxmox.ListFiles(client, c.Node, c.TemplateStoragePool, proxmox.ContentType_Template)
+	if err != nil {
+		err := fmt.Errorf("error listing templates in storage %s: %s", c.TemplateStoragePool, err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	if proxmox.CheckFileExistence(c.TemplateFile, files) {
+		ui.Say(fmt.Sprintf("Template %s already present in %s, skipping download", c.TemplateFile, c.TemplateStoragePool))
+		return multistep.ActionContinue
+	}
+
+	ui.Say(fmt.Sprintf("Downloading template %s to %s from %s", c.TemplateFile, c.TemplateStoragePool, c.TemplateURL))
+	fallback, err := downloadTemplateOnNode(ctx, client, c)
+	if err == nil {
+		return multistep.ActionContinue
+	}
+	if !fallback {
+		err := fmt.Errorf("error downloading template on node: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	ui.Say(fmt.Sprintf("Node could not download the template (%s), downloading from the Packer host instead", err))
+	err = downloadTemplateOnHost(ctx, ui, client, c)
+	if err != nil {
+		err := fmt.Errorf("error downloading template: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	return multistep.ActionContinue
+}
+
+func (s *stepDownloadTemplate) Cleanup(state multistep.StateBag) {}
+
+// downloadClient is the part of the Proxmox client downloadTemplateOnNode uses.
+type downloadClient interface {
+	CreateItemReturnStatus(params map[string]interface{}, url string) (string, error)
+	GetTaskExitstatus(taskUpid string) (interface{}, error)
+	Delete(url string) error
+	DeleteWithTask(url string) (string, error)
+}
+
+// downloadTaskPollInterval is the time between two checks of the download task.
+var downloadTaskPollInterval = 2 * time.Second
+
+// downloadTemplateOnNode asks the node to fetch template_url into the template
+// storage, letting Proxmox verify the checksum, and waits for it up to
+// template_download_timeout.
+//
+// It reports whether the template should be downloaded on the Packer host
+// instead: when the download-url API is rejected, or when its task fails, once
+// any partial file is deleted. A timed out task is stopped, but isn't retried
+// as the node may still be writing the file.
+func downloadTemplateOnNode(ctx context.Context, client downloadClient, c *Config) (bool, error) {
+	params := map[string]interface{}{
+		"url":      c.TemplateURL,
+		"content":  "vztmpl",
+		"filename": c.TemplateFile,
+	}
+	if strings.ToLower(c.TemplateChecksum) != "none" {
+		algorithm, value, err := parseChecksum(c.TemplateChecksum)
+		if err != nil {
+			return false, err
+		}
+		params["checksum-algorithm"] = algorithm
+		params["checksum"] = value
+	}
+
+	url := fmt.Sprintf("/nodes/%s/storage/%s/download-url", c.Node, c.TemplateStoragePool)
+	body, err := client.CreateItemReturnStatus(params, url)
+	if err != nil {
+		return downloadRejected(err), err
+	}
+	var response struct {
+		Data string `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(body), &response); err != nil || !strings.HasPrefix(response.Data, "UPID:") {
+		return false, fmt.Errorf("unexpected download-url response: %s", body)
+	}
+	upid := response.Data
+
+	timeout := time.NewTimer(c.TemplateDownloadTimeout)
+	defer timeout.Stop()
+	for {
+		exitStatus, err := client.GetTaskExitstatus(upid)
+		switch {
+		case exitStatus == nil && err != nil:
+			log.Printf("[WARN] Could not read status of task %s: %s", upid, err)
+		case err != nil:
+			volid := fmt.Sprintf("%s:vztmpl/%s", c.TemplateStoragePool, c.TemplateFile)
+			if _, derr := client.DeleteWithTask(fmt.Sprintf("/nodes/%s/storage/%s/content/%s", c.Node, c.TemplateStoragePool, volid)); derr != nil {
+				log.Printf("[DEBUG] No partial template to delete: %s", derr)
+			}
+			return true, err
+		case exitStatus != nil:
+			return false, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			client.Delete(fmt.Sprintf("/nodes/%s/tasks/%s", c.Node, upid))
+			return false, ctx.Err()
+		case <-timeout.C:
+			client.Delete(fmt.Sprintf("/nodes/%s/tasks/%s", c.Node, upid))
+			return false, fmt.Errorf("download task %s did not finish in %s", upid, c.TemplateDownloadTimeout)
+		case <-time.After(downloadTaskPollInterval):
+		}
+	}
+}
+
+// downloadRejected reports whether the error of a download-url call means the
+// API is not available (older Proxmox VE) or not permitted. The error is the
+// HTTP status of the request.
+func downloadRejected(err error) bool {
+	return strings.HasPrefix(err.Error(), "403") || strings.HasPrefix(err.Error(), "501")
+}
+
+// downloadTemplateOnHost fetches template_url on the Packer host, verifies its
+// checksum and uploads it to the template storage.
+func downloadTemplateOnHost(ctx context.Context, ui packersdk.Ui, client *proxmox.Client, c *Config) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.TemplateURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status fetching %s: %s", c.TemplateURL, resp.Status)
+	}
+
+	tmpFile, err := os.CreateTemp("", "vztmpl")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	var w io.Writer = tmpFile
+	var h hash.Hash
+	var expected string
+	if strings.ToLower(c.TemplateChecksum) != "none" {
+		var algorithm string
+		algorithm, expected, err = parseChecksum(c.TemplateChecksum)
+		if err != nil {
+			return err
+		}
+		h = newChecksumHash(algorithm)
+		w = io.MultiWriter(tmpFile, h)
+	}
+
+	ui.Say("Transferring template to local path...")
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return err
+	}
+
+	if h != nil {
+		actual := hex.EncodeToString(h.Sum(nil))
+		if !strings.EqualFold(actual, expected) {
+			return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", c.TemplateURL, expected, actual)
+		}
+	}
+
+	if _, err := tmpFile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	ui.Say(fmt.Sprintf("Upload template %s to %s...", c.TemplateFile, c.TemplateStoragePool))
+	return client.Upload(c.Node, c.TemplateStoragePool, "vztmpl", c.TemplateFile, tmpFile)
+}
+
+// parseChecksum splits a `type:value` checksum into the algorithm name and its
+// hex encoded value.
+func parseChecksum(checksum string) (string, string, error) {
+	parts := strings.SplitN(checksum, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", "", fmt.Errorf("checksum %q must be in the form type:value", checksum)
+	}
+	algorithm := strings.ToLower(parts[0])
+	if newChecksumHash(algorithm) == nil {
+		return "", "", fmt.Errorf("unsupported checksum type %q", parts[0])
+	}
+	if _, err := hex.DecodeString(parts[1]); err != nil {
+		return "", "", fmt.Errorf("checksum value %q is not hex encoded", parts[1])
+	}
+	return algorithm, strings.ToLower(parts[1]), nil
+}
+
+func newChecksumHash(algorithm string) hash.Hash {
+	switch algorithm {
+	case "md5":
+		return md5.New()
+	case "sha1":
+		return sha1.New()
+	case "sha224":
+		return sha256.New224()
+	case "sha256":
+		return sha256.New()
+	case "sha384":
+		return sha512.New384()
+	case "sha512":
+		return sha512.New()
+	}
+	return nil
+}
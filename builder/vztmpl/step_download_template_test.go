@@ -0,0 +1,101 @@
+package vztmpl
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseChecksum(t *testing.T) {
+	algorithm, value, err := parseChecksum("SHA256:ABCDEF0123")
+	require.NoError(t, err)
+	require.Equal(t, "sha256", algorithm)
+	require.Equal(t, "abcdef0123", value)
+
+	algorithm, _, err = parseChecksum("md5:d41d8cd98f00b204e9800998ecf8427e")
+	require.NoError(t, err)
+	require.Equal(t, "md5", algorithm)
+
+	for _, checksum := range []string{"", "sha256", "sha256:", "crc32:abcd", "sha256:xyz"} {
+		_, _, err := parseChecksum(checksum)
+		require.Error(t, err, checksum)
+	}
+}
+
+// fakeDownloadClient runs a download-url task ending with exitStatus, or never
+// ending when it is nil.
+type fakeDownloadClient struct {
+	postErr    error
+	exitStatus interface{}
+	deleted    []string
+}
+
+func (f *fakeDownloadClient) CreateItemReturnStatus(params map[string]interface{}, url string) (string, error) {
+	if f.postErr != nil {
+		return "", f.postErr
+	}
+	return `{"data":"UPID:pve:0001:download"}`, nil
+}
+
+func (f *fakeDownloadClient) GetTaskExitstatus(taskUpid string) (interface{}, error) {
+	if f.exitStatus != nil && f.exitStatus != "OK" {
+		return f.exitStatus, errors.New(f.exitStatus.(string))
+	}
+	return f.exitStatus, nil
+}
+
+func (f *fakeDownloadClient) Delete(url string) error {
+	f.deleted = append(f.deleted, url)
+	return nil
+}
+
+func (f *fakeDownloadClient) DeleteWithTask(url string) (string, error) {
+	f.deleted = append(f.deleted, url)
+	return "OK", nil
+}
+
+func TestDownloadTemplateOnNode(t *testing.T) {
+	downloadTaskPollInterval = time.Millisecond
+	c := &Config{
+		Node:                    "pve",
+		TemplateStoragePool:     "local",
+		TemplateFile:            "debian.tar.zst",
+		TemplateURL:             "http://example.com/debian.tar.zst",
+		TemplateChecksum:        "none",
+		TemplateDownloadTimeout: time.Second,
+	}
+
+	client := &fakeDownloadClient{exitStatus: "OK"}
+	fallback, err := downloadTemplateOnNode(context.Background(), client, c)
+	require.NoError(t, err)
+	require.False(t, fallback)
+
+	// The node can't reach the URL: the partial file is deleted before
+	// falling back to the Packer host
+	client = &fakeDownloadClient{exitStatus: "could not resolve host"}
+	fallback, err = downloadTemplateOnNode(context.Background(), client, c)
+	require.Error(t, err)
+	require.True(t, fallback)
+	require.Equal(t, []string{"/nodes/pve/storage/local/content/local:vztmpl/debian.tar.zst"}, client.deleted)
+
+	client = &fakeDownloadClient{postErr: errors.New("501 Method not implemented")}
+	fallback, err = downloadTemplateOnNode(context.Background(), client, c)
+	require.Error(t, err)
+	require.True(t, fallback)
+
+	client = &fakeDownloadClient{postErr: errors.New("400 Parameter verification failed")}
+	fallback, err = downloadTemplateOnNode(context.Background(), client, c)
+	require.Error(t, err)
+	require.False(t, fallback)
+
+	// A timed out task is stopped, without falling back
+	c.TemplateDownloadTimeout = 20 * time.Millisecond
+	client = &fakeDownloadClient{}
+	fallback, err = downloadTemplateOnNode(context.Background(), client, c)
+	require.ErrorContains(t, err, "did not finish")
+	require.False(t, fallback)
+	require.Equal(t, []string{"/nodes/pve/tasks/UPID:pve:0001:download"}, client.deleted)
+}
@@ -0,0 +1,159 @@
+package vztmpl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/template/interpolate"
+)
+
+type s3KeyData struct {
+	BuildName string
+	Name      string
+	File      string
+}
+
+// stepExportS3 uploads the template archive to s3_bucket with a multipart
+// upload, along with its `.sha256` checksum and `.json` metadata objects.
+//
+// It sets the s3URL state, and the templatePath and templateDigest states when
+// the template is only exported to S3.
+type stepExportS3 struct{}
+
+func (s *stepExportS3) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	c := state.Get("config").(*Config)
+
+	if c.S3Bucket == "" {
+		return multistep.ActionContinue
+	}
+
+	archivePath := state.Get("templateArchive").(string)
+	metadata := newTemplateMetadata(c, state)
+
+	c.ctx.Data = &s3KeyData{
+		BuildName: c.PackerBuildName,
+		Name:      metadata.Name,
+		File:      metadata.File,
+	}
+	key, err := interpolate.Render(c.S3Key, &c.ctx)
+	if err != nil {
+		err := fmt.Errorf("error rendering s3_key: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	checksum, size, err := localTemplateChecksum(archivePath, "")
+	if err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	metadata.Size = size
+	metadata.SHA256 = checksum
+
+	ui.Say(fmt.Sprintf("Exporting template to s3://%s/%s", c.S3Bucket, key))
+	if err := exportS3(ctx, c, key, archivePath, metadata); err != nil {
+		err := fmt.Errorf("error exporting template to S3: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	s3URL := fmt.Sprintf("s3://%s/%s", c.S3Bucket, key)
+	state.Put("s3URL", s3URL)
+	if _, ok := state.GetOk("templatePath"); !ok {
+		state.Put("templatePath", s3URL)
+	}
+	if _, ok := state.GetOk("templateDigest"); !ok {
+		state.Put("templateDigest", "sha256:"+checksum)
+		generatedData := state.Get("generated_data").(map[string]interface{})
+		generatedData["TemplateDigest"] = "sha256:" + checksum
+	}
+
+	return multistep.ActionContinue
+}
+
+func (s *stepExportS3) Cleanup(state multistep.StateBag) {}
+
+// newS3Uploader returns an uploader for the object store of the config.
+func newS3Uploader(c *Config) (*s3manager.Uploader, error) {
+	awsConfig := aws.NewConfig().
+		WithRegion(c.S3Region).
+		WithS3ForcePathStyle(c.S3ForcePathStyle.True())
+	if c.S3Endpoint != "" {
+		awsConfig = awsConfig.WithEndpoint(c.S3Endpoint)
+	}
+	if c.S3AccessKey != "" {
+		awsConfig = awsConfig.WithCredentials(credentials.NewStaticCredentials(c.S3AccessKey, c.S3SecretKey, ""))
+	}
+	sess, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, err
+	}
+	return s3manager.NewUploader(sess, func(u *s3manager.Uploader) {
+		u.PartSize = int64(c.S3PartSize) * 1024 * 1024
+	}), nil
+}
+
+// exportS3 uploads the archive at archivePath to key, then its checksum and
+// metadata next to it. The checksum and metadata are also set as object
+// metadata of the archive.
+func exportS3(ctx context.Context, c *Config, key string, archivePath string, metadata *templateMetadata) error {
+	uploader, err := newS3Uploader(c)
+	if err != nil {
+		return err
+	}
+
+	upload := func(key string, body io.Reader, objectMetadata map[string]*string) error {
+		input := &s3manager.UploadInput{
+			Bucket:   aws.String(c.S3Bucket),
+			Key:      aws.String(key),
+			Body:     body,
+			Metadata: objectMetadata,
+		}
+		if c.S3ServerSideEncryption != "" {
+			input.ServerSideEncryption = aws.String(c.S3ServerSideEncryption)
+		}
+		if c.S3KMSKeyID != "" {
+			input.SSEKMSKeyId = aws.String(c.S3KMSKeyID)
+		}
+		_, err := uploader.UploadWithContext(ctx, input)
+		return err
+	}
+
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	objectMetadata := map[string]*string{
+		"Sha256": aws.String(metadata.SHA256),
+	}
+	if metadata.ContentDigest != "" {
+		objectMetadata["Content-Digest"] = aws.String(metadata.ContentDigest)
+	}
+	if err := upload(key, f, objectMetadata); err != nil {
+		return err
+	}
+
+	checksum := fmt.Sprintf("%s  %s\n", metadata.SHA256, metadata.File)
+	if err := upload(key+".sha256", bytes.NewReader([]byte(checksum)), nil); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+	return upload(key+".json", bytes.NewReader(append(data, '\n')), nil)
+}
@@ -0,0 +1,148 @@
+package vztmpl
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/packer-plugin-sdk/template/config"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeS3 is an S3-compatible endpoint keeping the uploaded objects in memory,
+// with path-style addressing.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	headers map[string]http.Header
+	parts   map[string]map[int][]byte
+	calls   []string
+	errs    []error
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{
+		objects: make(map[string][]byte),
+		headers: make(map[string]http.Header),
+		parts:   make(map[string]map[int][]byte),
+	}
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		s.errs = append(s.errs, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	query := r.URL.Query()
+	key := r.URL.Path
+
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		s.calls = append(s.calls, "CreateMultipartUpload")
+		s.headers[key] = r.Header
+		s.parts[key] = make(map[int][]byte)
+		fmt.Fprintf(w, `<InitiateMultipartUploadResult><UploadId>upload</UploadId></InitiateMultipartUploadResult>`)
+	case r.Method == http.MethodPut && query.Has("partNumber"):
+		s.calls = append(s.calls, "UploadPart")
+		n, _ := strconv.Atoi(query.Get("partNumber"))
+		s.parts[key][n] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"part%d"`, n))
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		s.calls = append(s.calls, "CompleteMultipartUpload")
+		var numbers []int
+		for n := range s.parts[key] {
+			numbers = append(numbers, n)
+		}
+		sort.Ints(numbers)
+		var object []byte
+		for _, n := range numbers {
+			object = append(object, s.parts[key][n]...)
+		}
+		s.objects[key] = object
+		fmt.Fprintf(w, `<CompleteMultipartUploadResult><Key>%s</Key></CompleteMultipartUploadResult>`, key)
+	case r.Method == http.MethodPut:
+		s.calls = append(s.calls, "PutObject")
+		s.objects[key] = body
+		s.headers[key] = r.Header
+	default:
+		s.errs = append(s.errs, fmt.Errorf("unexpected request %s %s", r.Method, r.URL))
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func TestExportS3(t *testing.T) {
+	tests := []struct {
+		name      string
+		size      int
+		multipart bool
+	}{
+		{"single", 8, false},
+		{"multipart", 11 * 1024 * 1024, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeS3()
+			server := httptest.NewServer(fake)
+			defer server.Close()
+
+			data := bytes.Repeat([]byte("template"), tt.size/8)
+			archivePath := filepath.Join(t.TempDir(), "archive")
+			require.NoError(t, os.WriteFile(archivePath, data, 0644))
+
+			c := &Config{
+				S3Bucket:               "templates",
+				S3Endpoint:             server.URL,
+				S3Region:               "us-east-1",
+				S3AccessKey:            "minio",
+				S3SecretKey:            "minio123",
+				S3ForcePathStyle:       config.TriTrue,
+				S3ServerSideEncryption: "AES256",
+				S3PartSize:             5,
+			}
+			checksum, size, err := localTemplateChecksum(archivePath, "")
+			require.NoError(t, err)
+			metadata := &templateMetadata{
+				Name:   "web_custom",
+				File:   "web_custom.tar.gz",
+				Size:   size,
+				SHA256: checksum,
+			}
+			require.NoError(t, exportS3(context.Background(), c, "lxc/web_custom.tar.gz", archivePath, metadata))
+
+			fake.mu.Lock()
+			defer fake.mu.Unlock()
+			require.Empty(t, fake.errs)
+
+			require.Equal(t, data, fake.objects["/templates/lxc/web_custom.tar.gz"])
+			require.Equal(t, checksum+"  web_custom.tar.gz\n", string(fake.objects["/templates/lxc/web_custom.tar.gz.sha256"]))
+			require.Contains(t, string(fake.objects["/templates/lxc/web_custom.tar.gz.json"]), `"sha256": "`+checksum+`"`)
+
+			archiveHeader := fake.headers["/templates/lxc/web_custom.tar.gz"]
+			require.Equal(t, checksum, archiveHeader.Get("X-Amz-Meta-Sha256"))
+			require.Equal(t, "AES256", archiveHeader.Get("X-Amz-Server-Side-Encryption"))
+
+			if tt.multipart {
+				require.Equal(t, []string{
+					"CreateMultipartUpload", "UploadPart", "UploadPart", "UploadPart", "CompleteMultipartUpload",
+					"PutObject", "PutObject",
+				}, fake.calls)
+			} else {
+				require.Equal(t, []string{"PutObject", "PutObject", "PutObject"}, fake.calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package vztmpl
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/Telmate/proxmox-api-go/proxmox"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// firewallRuleComment marks the rules created by stepFirewall, so that only
+// those get removed.
+const firewallRuleComment = "packer build rule"
+
+// stepFirewall enables the Proxmox firewall on the build container with
+// temporary rules, when firewall is set.
+//
+// It sets the firewall state used by stepRemoveFirewall.
+type stepFirewall struct{}
+
+func (s *stepFirewall) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	c := state.Get("config").(*Config)
+	client := state.Get("proxmoxClient").(*proxmox.Client)
+
+	if !c.Firewall {
+		return multistep.ActionContinue
+	}
+
+	ui.Say("Configuring firewall of LXC Container")
+	var source string
+	if c.Comm.Type == "ssh" || len(c.FirewallEgressAllow) > 0 {
+		var err error
+		if source, err = firewallSource(client, c); err != nil {
+			err := fmt.Errorf("error configuring firewall: %s", err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+	}
+	rules := firewallRules(c, source)
+	state.Put("firewall", true)
+
+	url := fmt.Sprintf("/nodes/%s/lxc/%d/firewall", c.Node, c.VMID)
+	for _, rule := range rules {
+		ui.Message(fmt.Sprintf("Adding rule %s", formatNetworkParam(rule)))
+		rule["enable"] = 1
+		rule["comment"] = firewallRuleComment
+		if err := client.Post(rule, url+"/rules"); err != nil {
+			err := fmt.Errorf("error adding firewall rule: %s", err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+	}
+
+	policyOut := "ACCEPT"
+	if len(c.FirewallEgressAllow) > 0 {
+		policyOut = "DROP"
+	}
+	options := map[string]interface{}{
+		"enable":     1,
+		"policy_in":  "DROP",
+		"policy_out": policyOut,
+	}
+	if err := client.Put(options, url+"/options"); err != nil {
+		err := fmt.Errorf("error enabling firewall: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	return multistep.ActionContinue
+}
+
+func (s *stepFirewall) Cleanup(state multistep.StateBag) {
+	if _, ok := state.GetOk("firewall"); !ok {
+		return
+	}
+	ui := state.Get("ui").(packersdk.Ui)
+	c := state.Get("config").(*Config)
+	client := state.Get("proxmoxClient").(*proxmox.Client)
+
+	if err := removeFirewall(client, c.Node, c.VMID); err != nil {
+		ui.Error(fmt.Sprintf("Error removing firewall rules of LXC Container: %s", err))
+	}
+}
+
+// firewallSource returns the address SSH connections to the container come
+// from: firewall_ssh_source, the Packer host, or with provision_via_node the
+// address of the node on the bridge of the container.
+func firewallSource(client *proxmox.Client, c *Config) (string, error) {
+	switch {
+	case c.FirewallSSHSource != "":
+		return c.FirewallSSHSource, nil
+	case c.ProvisionViaNode && c.SDNVNet != "":
+		// The VNet gateway is the node, VNets aren't node interfaces
+		if c.ProvisionGatewayIP != "" {
+			return c.ProvisionGatewayIP, nil
+		}
+		return c.ProvisionGatewayIP6, nil
+	case c.ProvisionViaNode:
+		bridge := containerNetwork(c)["bridge"].(string)
+		iface, err := client.GetItemConfigMapStringInterface(fmt.Sprintf("/nodes/%s/network/%s", c.Node, bridge), "network interface", "CONFIG")
+		if err != nil {
+			return "", fmt.Errorf("could not read bridge %s of node %s: %s", bridge, c.Node, err)
+		}
+		if address, _ := iface["address"].(string); address != "" {
+			return address, nil
+		}
+		if address, _ := iface["address6"].(string); address != "" {
+			return address, nil
+		}
+		return "", fmt.Errorf("node %s has no address on bridge %s, set firewall_ssh_source", c.Node, bridge)
+	}
+	source, err := httpServerIP(c)
+	if err != nil {
+		return "", fmt.Errorf("could not determine Packer host address: %s", err)
+	}
+	return source, nil
+}
+
+// firewallRules returns the rules accepting SSH from source and outgoing
+// connections to firewall_egress_allow and source.
+func firewallRules(c *Config, source string) []map[string]interface{} {
+	var rules []map[string]interface{}
+	if len(c.FirewallEgressAllow) > 0 {
+		for _, dest := range c.FirewallEgressAllow {
+			rules = append(rules, map[string]interface{}{
+				"type":   "out",
+				"action": "ACCEPT",
+				"dest":   dest,
+			})
+		}
+		// The HTTP server runs on the Packer host, or is forwarded through
+		// the node
+		rules = append(rules, map[string]interface{}{
+			"type":   "out",
+			"action": "ACCEPT",
+			"dest":   source,
+		})
+	}
+	if c.Comm.Type == "ssh" {
+		rules = append(rules, map[string]interface{}{
+			"type":   "in",
+			"action": "ACCEPT",
+			"proto":  "tcp",
+			"dport":  c.Comm.SSHPort,
+			"source": source,
+		})
+	}
+	return rules
+}
+
+// removeFirewall deletes the rules added by stepFirewall and disables the
+// firewall of the container.
+func removeFirewall(client *proxmox.Client, node string, vmid int) error {
+	url := fmt.Sprintf("/nodes/%s/lxc/%d/firewall", node, vmid)
+	rules, err := client.GetItemListInterfaceArray(url + "/rules")
+	if err != nil {
+		return err
+	}
+
+	// Positions shift on deletion, remove from the last one
+	var positions []int
+	for _, r := range rules {
+		rule, _ := r.(map[string]interface{})
+		if comment, _ := rule["comment"].(string); comment != firewallRuleComment {
+			continue
+		}
+		if pos, ok := rule["pos"].(float64); ok {
+			positions = append(positions, int(pos))
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(positions)))
+	for _, pos := range positions {
+		if err := client.Delete(fmt.Sprintf("%s/rules/%d", url, pos)); err != nil {
+			return err
+		}
+	}
+
+	options := map[string]interface{}{
+		"enable": 0,
+		"delete": "policy_in,policy_out",
+	}
+	return client.Put(options, url+"/options")
+}
+
+// stepRemoveFirewall removes the temporary firewall rules before the container
+// is exported, so that they don't end up in the template.
+type stepRemoveFirewall struct{}
+
+func (s *stepRemoveFirewall) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	c := state.Get("config").(*Config)
+	client := state.Get("proxmoxClient").(*proxmox.Client)
+
+	if _, ok := state.GetOk("firewall"); !ok {
+		return multistep.ActionContinue
+	}
+
+	ui.Say("Removing firewall rules of LXC Container")
+	if err := removeFirewall(client, c.Node, c.VMID); err != nil {
+		err := fmt.Errorf("error removing firewall rules: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	state.Remove("firewall")
+
+	return multistep.ActionContinue
+}
+
+func (s *stepRemoveFirewall) Cleanup(state multistep.StateBag) {}
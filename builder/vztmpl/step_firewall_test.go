@@ -0,0 +1,45 @@
+package vztmpl
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFirewallSource(t *testing.T) {
+	interfaces := map[string]map[string]interface{}{
+		"/nodes/pve/network/vmbr0": {"iface": "vmbr0", "address": "10.0.0.2", "cidr": "10.0.0.2/24"},
+	}
+	mockAPI := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_ = json.NewEncoder(rw).Encode(map[string]interface{}{"data": interfaces[req.URL.Path]})
+	}))
+	defer mockAPI.Close()
+
+	pmURL, _ := url.Parse(mockAPI.URL)
+	client, err := newProxmoxClient(Config{
+		proxmoxURL: pmURL,
+		Username:   "dummy@vmhost!test-token",
+		Token:      "token",
+	})
+	require.NoError(t, err)
+
+	c := &Config{Node: "pve", ProvisionViaNode: true, ProvisionGatewayIP: "10.0.0.1"}
+	source, err := firewallSource(client, c)
+	require.NoError(t, err)
+	require.Equal(t, "10.0.0.2", source)
+
+	c.FirewallSSHSource = "192.168.1.0/24"
+	source, err = firewallSource(client, c)
+	require.NoError(t, err)
+	require.Equal(t, "192.168.1.0/24", source)
+
+	c.FirewallSSHSource = ""
+	c.SDNVNet = "vnet1"
+	source, err = firewallSource(client, c)
+	require.NoError(t, err)
+	require.Equal(t, "10.0.0.1", source)
+}
@@ -0,0 +1,107 @@
+package vztmpl
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// generalizeItems lists, in the order they run, the generalize_skip names and
+// the commands removing the matching build specific state from the container.
+var generalizeItems = []struct {
+	name   string
+	script string
+}{
+	{"machine_id", `if [ -e /etc/machine-id ]; then : > /etc/machine-id; fi; ` +
+		`if [ -f /var/lib/dbus/machine-id ] && [ ! -L /var/lib/dbus/machine-id ]; then rm -f /var/lib/dbus/machine-id; fi`},
+	{"ssh_host_keys", `rm -f /etc/ssh/ssh_host_*`},
+	// The distribution specific part is added by generalizeScript
+	{"package_cache", ``},
+	{"logs", `find /var/log -type f \( -name '*.gz' -o -name '*.[0-9]' -o -name '*.old' \) -exec rm -f {} \; ; ` +
+		`find /var/log -type f -exec sh -c ': > "$1"' _ {} \; ; rm -rf /var/log/journal/*`},
+	{"shell_history", `rm -f /root/.*_history /home/*/.*_history`},
+	{"dhcp_leases", `rm -f /var/lib/dhcp/*.leases /var/lib/dhclient/*.lease* /var/lib/NetworkManager/*.lease /var/lib/udhcpc/*`},
+	{"resolv_conf", `if [ ! -L /etc/resolv.conf ]; then : > /etc/resolv.conf; fi`},
+	{"hostname", `echo localhost > /etc/hostname`},
+}
+
+var packageCacheCommands = map[string]string{
+	"alpine": "rm -rf /var/cache/apk/*",
+	"debian": "apt-get clean && rm -rf /var/lib/apt/lists/*",
+	"fedora": "if command -v dnf >/dev/null; then dnf clean all; else yum clean all; fi",
+	"arch":   "pacman -Scc --noconfirm",
+	"suse":   "zypper --non-interactive clean --all",
+}
+
+// stepGeneralize removes the identity of the build container, and the state it
+// accumulated, before it is exported, so that the containers created from the
+// template don't share it.
+type stepGeneralize struct{}
+
+func (s *stepGeneralize) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	c := state.Get("config").(*Config)
+
+	if !c.Generalize && len(c.GeneralizeCommands) == 0 {
+		return multistep.ActionContinue
+	}
+
+	ui.Say("Generalizing LXC Container")
+	sshClient, err := newNodeSSHClient(c)
+	if err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	defer sshClient.Close()
+
+	// Pairs of message and script
+	var scripts [][2]string
+	if c.Generalize {
+		osRelease, err := runContainerCommand(sshClient, c.VMID, `. /etc/os-release && echo "$ID $ID_LIKE"`)
+		if err != nil {
+			err := fmt.Errorf("error detecting distribution: %s", err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		distro := detectDistro(osRelease)
+
+		skip := make(map[string]bool)
+		for _, name := range c.GeneralizeSkip {
+			skip[name] = true
+		}
+		for _, item := range generalizeItems {
+			if skip[item.name] {
+				continue
+			}
+			script := item.script
+			if item.name == "package_cache" {
+				if script = packageCacheCommands[distro]; script == "" {
+					ui.Message("Skipping package_cache, unsupported distribution")
+					continue
+				}
+			}
+			scripts = append(scripts, [2]string{"Clearing " + item.name, script})
+		}
+	}
+	for _, command := range c.GeneralizeCommands {
+		scripts = append(scripts, [2]string{"Running " + command, command})
+	}
+
+	for _, script := range scripts {
+		ui.Message(script[0])
+		if _, err := runContainerCommand(sshClient, c.VMID, script[1]); err != nil {
+			err := fmt.Errorf("error generalizing container: %s", err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+	}
+
+	return multistep.ActionContinue
+}
+
+func (s *stepGeneralize) Cleanup(state multistep.StateBag) {}
@@ -0,0 +1,130 @@
+package vztmpl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"golang.org/x/crypto/ssh"
+)
+
+// stepHTTPTunnel forwards the Packer HTTP server to 127.0.0.1 in the container
+// when provision_via_node is set, through an SSH connection to the container
+// made from the node.
+//
+// It overrides the http_ip state used by provisioners.
+type stepHTTPTunnel struct {
+	nodeClient *ssh.Client
+	client     *ssh.Client
+	listener   net.Listener
+}
+
+func (s *stepHTTPTunnel) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	c := state.Get("config").(*Config)
+
+	httpPort, _ := state.Get("http_port").(int)
+	if !c.ProvisionViaNode || httpPort == 0 {
+		return multistep.ActionContinue
+	}
+
+	ui.Say("Forwarding HTTP server to LXC Container through the node")
+	var err error
+	s.nodeClient, err = newNodeSSHClient(c)
+	if err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	if err := s.listen(state, c, httpPort); err != nil {
+		err := fmt.Errorf("error forwarding HTTP server: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	localHost := c.HTTPAddress
+	if localHost == "" || localHost == "0.0.0.0" {
+		localHost = "127.0.0.1"
+	}
+	localAddr := net.JoinHostPort(localHost, strconv.Itoa(httpPort))
+	go func() {
+		for {
+			remote, err := s.listener.Accept()
+			if err != nil {
+				return
+			}
+			go forwardConn(remote, localAddr)
+		}
+	}()
+
+	ui.Message(fmt.Sprintf("HTTP server available in the container at 127.0.0.1:%d", httpPort))
+	state.Put("http_ip", "127.0.0.1")
+
+	return multistep.ActionContinue
+}
+
+func (s *stepHTTPTunnel) Cleanup(state multistep.StateBag) {
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	if s.client != nil {
+		s.client.Close()
+	}
+	if s.nodeClient != nil {
+		s.nodeClient.Close()
+	}
+}
+
+// listen connects to the container SSH server from the node, and listens on
+// the loopback interface of the container.
+func (s *stepHTTPTunnel) listen(state multistep.StateBag, c *Config, port int) error {
+	config, err := sshConfigWithHostKeys(&c.Comm)(state)
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(provisionHost(c), strconv.Itoa(c.Comm.SSHPort))
+	conn, err := s.nodeClient.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	clientConn, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	s.client = ssh.NewClient(clientConn, chans, reqs)
+
+	s.listener, err = s.client.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	return err
+}
+
+// forwardConn copies data between remote and a new connection to localAddr.
+func forwardConn(remote net.Conn, localAddr string) {
+	defer remote.Close()
+
+	local, err := net.Dial("tcp", localAddr)
+	if err != nil {
+		log.Printf("[ERROR] Error connecting to HTTP server: %s", err)
+		return
+	}
+	defer local.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(local, remote)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(remote, local)
+		done <- struct{}{}
+	}()
+	<-done
+}
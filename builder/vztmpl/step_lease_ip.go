@@ -0,0 +1,147 @@
+package vztmpl
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/Telmate/proxmox-api-go/proxmox"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// ipLeaseDir holds one directory per leased address. It lives in the cluster
+// file system, so that mkdir atomically leases an address across all nodes.
+const ipLeaseDir = "/etc/pve/packer-ip-leases"
+
+// stepLeaseIP leases a provisioning address from provision_ip_pool, skipping
+// the addresses configured on the containers and VMs of the cluster.
+//
+// It sets provision_ip, and provision_mac when left empty.
+type stepLeaseIP struct {
+	lease string
+}
+
+func (s *stepLeaseIP) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	c := state.Get("config").(*Config)
+	client := state.Get("proxmoxClient").(*proxmox.Client)
+
+	if c.ProvisionIPPool == "" {
+		return multistep.ActionContinue
+	}
+
+	ui.Say("Leasing provisioning address from pool")
+	pool, err := parseIPPool(c.ProvisionIPPool, c.ProvisionIPPoolRange)
+	if err != nil {
+		err := fmt.Errorf("error parsing provision_ip_pool: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	used, err := usedAddresses(client)
+	if err != nil {
+		err := fmt.Errorf("error listing addresses in use: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	used[c.ProvisionGatewayIP] = true
+
+	sshClient, err := newNodeSSHClient(c)
+	if err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	defer sshClient.Close()
+
+	if _, err := runNodeCommand(sshClient, "mkdir -p "+ipLeaseDir); err != nil {
+		err := fmt.Errorf("error creating lease directory: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	for _, ip := range pool.free(used) {
+		_, err := runNodeCommand(sshClient, "mkdir "+shellQuote(path.Join(ipLeaseDir, ip)))
+		if err == nil {
+			s.lease = ip
+			break
+		}
+		// mkdir fails with EEXIST when another build already holds the lease
+		if !strings.Contains(err.Error(), "File exists") {
+			err := fmt.Errorf("error leasing %s: %s", ip, err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+	}
+	if s.lease == "" {
+		err := fmt.Errorf("no free address left in provision_ip_pool")
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	ui.Message(fmt.Sprintf("Leased %s", s.lease))
+	c.ProvisionIP = fmt.Sprintf("%s/%d", s.lease, pool.prefixLength())
+	c.Comm.SSHHost = s.lease
+	if c.ProvisionMac == "" {
+		c.ProvisionMac = poolMac(s.lease)
+	}
+
+	return multistep.ActionContinue
+}
+
+func (s *stepLeaseIP) Cleanup(state multistep.StateBag) {
+	if s.lease == "" {
+		return
+	}
+	ui := state.Get("ui").(packersdk.Ui)
+	c := state.Get("config").(*Config)
+
+	leasePath := path.Join(ipLeaseDir, s.lease)
+	sshClient, err := newNodeSSHClient(c)
+	if err != nil {
+		ui.Error(fmt.Sprintf("Error releasing address %s. Please remove %s manually: %s", s.lease, leasePath, err))
+		return
+	}
+	defer sshClient.Close()
+
+	if _, err := runNodeCommand(sshClient, "rmdir "+shellQuote(leasePath)); err != nil {
+		ui.Error(fmt.Sprintf("Error releasing address %s. Please remove %s manually: %s", s.lease, leasePath, err))
+	}
+}
+
+// usedAddresses returns the static addresses of all containers and VMs of the
+// cluster.
+func usedAddresses(client *proxmox.Client) (map[string]bool, error) {
+	list, err := client.GetVmList()
+	if err != nil {
+		return nil, err
+	}
+	resources, _ := list["data"].([]interface{})
+
+	used := make(map[string]bool)
+	for _, r := range resources {
+		resource, _ := r.(map[string]interface{})
+		node, _ := resource["node"].(string)
+		vmType, _ := resource["type"].(string)
+		vmid, _ := resource["vmid"].(float64)
+
+		url := fmt.Sprintf("/nodes/%s/%s/%d/config", node, vmType, int(vmid))
+		config, err := client.GetItemConfigMapStringInterface(url, "vm", "CONFIG")
+		if err != nil {
+			// Guests on offline nodes can't be checked
+			log.Printf("[WARN] Could not read configuration of %s %d: %s", vmType, int(vmid), err)
+			continue
+		}
+		for _, ip := range configAddresses(config) {
+			used[ip] = true
+		}
+	}
+	return used, nil
+}
@@ -0,0 +1,154 @@
+package vztmpl
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// templateMetadata is the JSON metadata written next to the template archive
+// in output_directory.
+type templateMetadata struct {
+	Name          string    `json:"name"`
+	File          string    `json:"file"`
+	Size          int64     `json:"size"`
+	SHA256        string    `json:"sha256"`
+	ContentDigest string    `json:"content_digest,omitempty"`
+	BuildName     string    `json:"build_name,omitempty"`
+	Node          string    `json:"node"`
+	Storage       string    `json:"storage,omitempty"`
+	Created       time.Time `json:"created"`
+}
+
+// newTemplateMetadata returns the metadata of the template, without its size
+// and checksum.
+func newTemplateMetadata(c *Config, state multistep.StateBag) *templateMetadata {
+	name := state.Get("templateName").(string)
+	metadata := &templateMetadata{
+		Name:      name,
+		File:      name + "." + state.Get("extension").(string),
+		BuildName: c.PackerBuildName,
+		Node:      c.Node,
+		Created:   time.Now().UTC(),
+	}
+	if !c.SkipUpload {
+		metadata.Storage = c.TemplateStoragePool
+	}
+	if digest, ok := state.GetOk("templateContentDigest"); ok {
+		metadata.ContentDigest = digest.(string)
+	}
+	return metadata
+}
+
+// stepOutputTemplate copies the template archive to output_directory, along
+// with a `.sha256` checksum file in the sha256sum format and a `.json`
+// metadata file.
+//
+// It sets the outputFiles state, and removes them if the build fails.
+type stepOutputTemplate struct{}
+
+func (s *stepOutputTemplate) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	c := state.Get("config").(*Config)
+
+	if c.OutputDirectory == "" {
+		return multistep.ActionContinue
+	}
+
+	archivePath := state.Get("templateArchive").(string)
+
+	ui.Say(fmt.Sprintf("Saving template to %s", c.OutputDirectory))
+	if err := os.MkdirAll(c.OutputDirectory, 0755); err != nil {
+		err := fmt.Errorf("error creating output directory: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	metadata := newTemplateMetadata(c, state)
+	files, err := writeOutputTemplate(c.OutputDirectory, archivePath, metadata)
+	state.Put("outputFiles", files)
+	if err != nil {
+		err := fmt.Errorf("error saving template to output directory: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	for _, f := range files {
+		ui.Message(f)
+	}
+
+	if c.SkipUpload {
+		state.Put("templateDigest", "sha256:"+metadata.SHA256)
+		generatedData := state.Get("generated_data").(map[string]interface{})
+		generatedData["TemplateDigest"] = "sha256:" + metadata.SHA256
+	}
+
+	return multistep.ActionContinue
+}
+
+func (s *stepOutputTemplate) Cleanup(state multistep.StateBag) {
+	if _, ok := state.GetOk("success"); ok {
+		return
+	}
+	if files, ok := state.GetOk("outputFiles"); ok {
+		for _, f := range files.([]string) {
+			os.Remove(f)
+		}
+	}
+}
+
+// writeOutputTemplate copies the archive at archivePath to dir as
+// metadata.File, and writes its checksum and metadata files next to it,
+// filling metadata.Size and metadata.SHA256. It returns the paths of the
+// files written, even on error.
+func writeOutputTemplate(dir string, archivePath string, metadata *templateMetadata) ([]string, error) {
+	var files []string
+
+	src, err := os.Open(archivePath)
+	if err != nil {
+		return files, err
+	}
+	defer src.Close()
+
+	dstPath := filepath.Join(dir, metadata.File)
+	dst, err := os.Create(dstPath)
+	if err != nil {
+		return files, err
+	}
+	files = append(files, dstPath)
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(dst, h), src)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return files, err
+	}
+	metadata.Size = size
+	metadata.SHA256 = hex.EncodeToString(h.Sum(nil))
+
+	checksumPath := dstPath + ".sha256"
+	files = append(files, checksumPath)
+	checksum := fmt.Sprintf("%s  %s\n", metadata.SHA256, metadata.File)
+	if err := os.WriteFile(checksumPath, []byte(checksum), 0644); err != nil {
+		return files, err
+	}
+
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return files, err
+	}
+	metadataPath := filepath.Join(dir, metadata.Name+".json")
+	files = append(files, metadataPath)
+	return files, os.WriteFile(metadataPath, append(data, '\n'), 0644)
+}
@@ -0,0 +1,40 @@
+package vztmpl
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteOutputTemplate(t *testing.T) {
+	dir := t.TempDir()
+	archivePath := filepath.Join(dir, "archive")
+	require.NoError(t, os.WriteFile(archivePath, []byte("template"), 0644))
+
+	outDir := filepath.Join(dir, "out")
+	require.NoError(t, os.Mkdir(outDir, 0755))
+	metadata := &templateMetadata{Name: "web_custom", File: "web_custom.tar.gz", Node: "pve"}
+	files, err := writeOutputTemplate(outDir, archivePath, metadata)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		filepath.Join(outDir, "web_custom.tar.gz"),
+		filepath.Join(outDir, "web_custom.tar.gz.sha256"),
+		filepath.Join(outDir, "web_custom.json"),
+	}, files)
+
+	sum := "5cde0f1298f41f7d1c8b907a36992a7a513225a2615bd6e307bf1a9149b06b40"
+	require.Equal(t, sum, metadata.SHA256)
+	checksum, err := os.ReadFile(files[1])
+	require.NoError(t, err)
+	require.Equal(t, sum+"  web_custom.tar.gz\n", string(checksum))
+
+	data, err := os.ReadFile(files[2])
+	require.NoError(t, err)
+	var read templateMetadata
+	require.NoError(t, json.Unmarshal(data, &read))
+	require.Equal(t, int64(8), read.Size)
+	require.Equal(t, sum, read.SHA256)
+}
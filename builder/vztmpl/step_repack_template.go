@@ -0,0 +1,96 @@
+package vztmpl
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// stepRepackTemplate turns the downloaded vzdump archive into a clean template
+// archive, when repack is set.
+//
+// It replaces the templateArchive and extension states.
+type stepRepackTemplate struct{}
+
+func (s *stepRepackTemplate) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	c := state.Get("config").(*Config)
+
+	if !c.Repack {
+		return multistep.ActionContinue
+	}
+
+	ui.Say(fmt.Sprintf("Repacking template archive with %s compression", c.TemplateCompression))
+	srcPath := state.Get("templateArchive").(string)
+	dstPath, digest, err := repackTemplateFile(srcPath, c.TemplateCompression, repackOptions{
+		exclude:         c.RepackExclude,
+		reproducible:    c.Reproducible,
+		sourceDateEpoch: time.Unix(c.SourceDateEpoch, 0),
+	})
+	if err != nil {
+		err := fmt.Errorf("error repacking template archive: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	ui.Message(fmt.Sprintf("Content digest: %s", digest))
+	os.Remove(srcPath)
+	state.Put("templateArchive", dstPath)
+	state.Put("extension", templateCompressions[c.TemplateCompression])
+	state.Put("templateContentDigest", digest)
+	generatedData := state.Get("generated_data").(map[string]interface{})
+	generatedData["TemplateContentDigest"] = digest
+
+	return multistep.ActionContinue
+}
+
+func (s *stepRepackTemplate) Cleanup(state multistep.StateBag) {}
+
+// repackTemplateFile repacks the archive at srcPath into a new temporary file
+// compressed with compression. It returns its path and the SHA-256 digest of
+// the uncompressed tar stream, which doesn't depend on the compressor.
+func repackTemplateFile(srcPath string, compression string, opts repackOptions) (string, string, error) {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return "", "", err
+	}
+	defer src.Close()
+
+	tr, err := decompress(src)
+	if err != nil {
+		return "", "", err
+	}
+	defer tr.Close()
+
+	dst, err := os.CreateTemp("", "vztmpl")
+	if err != nil {
+		return "", "", err
+	}
+	defer dst.Close()
+
+	h := sha256.New()
+	err = func() error {
+		cw, err := compress(dst, compression, opts.reproducible)
+		if err != nil {
+			return err
+		}
+		if err := repackArchive(tr, io.MultiWriter(cw, h), opts); err != nil {
+			cw.Close()
+			return err
+		}
+		return cw.Close()
+	}()
+	if err != nil {
+		os.Remove(dst.Name())
+		return "", "", err
+	}
+	return dst.Name(), "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
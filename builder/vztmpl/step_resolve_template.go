@@ -0,0 +1,87 @@
+package vztmpl
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Telmate/proxmox-api-go/proxmox"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// stepResolveTemplate picks the base template matching template_os and
+// template_version from the node's appliance index and the template storage.
+//
+// The newest match is downloaded when it isn't in the storage yet, and the
+// resolved file name replaces template_file for the later steps.
+type stepResolveTemplate struct{}
+
+func (s *stepResolveTemplate) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	c := state.Get("config").(*Config)
+	client := state.Get("proxmoxClient").(*proxmox.Client)
+
+	if c.TemplateOS == "" {
+		return multistep.ActionContinue
+	}
+
+	ui.Say(fmt.Sprintf("Resolving template for %s %s", c.TemplateOS, c.TemplateVersion))
+
+	available, err := proxmox.ListTemplates(client, c.Node)
+	if err != nil {
+		err := fmt.Errorf("error listing available templates: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	var catalogFiles []string
+	for _, t := range *available {
+		if t.Type == "lxc" {
+			catalogFiles = append(catalogFiles, t.Template)
+		}
+	}
+
+	stored, err := proxmox.ListFiles(client, c.Node, c.TemplateStoragePool, proxmox.ContentType_Template)
+	if err != nil {
+		err := fmt.Errorf("error listing templates in storage %s: %s", c.TemplateStoragePool, err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	var storageFiles []string
+	for _, f := range *stored {
+		storageFiles = append(storageFiles, f.Name)
+	}
+
+	template, ok := newestApplianceTemplate(catalogFiles, storageFiles, c.TemplateOS, c.TemplateVersion)
+	if !ok {
+		err := fmt.Errorf("no template found for %s %s", c.TemplateOS, c.TemplateVersion)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	c.TemplateFile = template.File
+	ui.Say(fmt.Sprintf("Resolved template %s", c.TemplateFile))
+
+	if !template.InStorage {
+		ui.Say(fmt.Sprintf("Downloading template %s to %s", c.TemplateFile, c.TemplateStoragePool))
+		err := proxmox.DownloadLxcTemplate(client, proxmox.ConfigContent_Template{
+			Node:     c.Node,
+			Storage:  c.TemplateStoragePool,
+			Template: c.TemplateFile,
+		})
+		if err != nil {
+			err := fmt.Errorf("error downloading template %s: %s", c.TemplateFile, err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+	}
+
+	generatedData := state.Get("generated_data").(map[string]interface{})
+	generatedData["TemplateFile"] = c.TemplateFile
+
+	return multistep.ActionContinue
+}
+
+func (s *stepResolveTemplate) Cleanup(state multistep.StateBag) {}
@@ -0,0 +1,73 @@
+package vztmpl
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Telmate/proxmox-api-go/proxmox"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// stepRetention deletes the older templates of the build from the template
+// storage once the new one is saved, following keep_last and keep_days.
+// Templates a container is being created from are kept.
+//
+// Failures are reported without failing the build, the template being saved.
+type stepRetention struct{}
+
+func (s *stepRetention) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	c := state.Get("config").(*Config)
+	client := state.Get("proxmoxClient").(*proxmox.Client)
+
+	if c.KeepLast == 0 && c.KeepDays == 0 {
+		return multistep.ActionContinue
+	}
+
+	ui.Say("Applying template retention")
+	files, err := proxmox.ListFiles(client, c.Node, c.TemplateStoragePool, proxmox.ContentType_Template)
+	if err != nil {
+		ui.Error(fmt.Sprintf("Error listing templates, none deleted: %s", err))
+		return multistep.ActionContinue
+	}
+
+	saved := state.Get("templatePath").(string)
+	match := state.Get("templateNameMatcher").(func(string) bool)
+	var expired []proxmox.Content_FileProperties
+	for _, f := range expiredTemplates(*files, match, c.KeepLast, c.KeepDays, time.Now()) {
+		if f.Name != saved {
+			expired = append(expired, f)
+		}
+	}
+	if len(expired) == 0 {
+		ui.Message("No template to delete")
+		return multistep.ActionContinue
+	}
+
+	inUse, err := templatesInUse(client, expired)
+	if err != nil {
+		ui.Error(fmt.Sprintf("Error looking for templates in use, none deleted: %s", err))
+		return multistep.ActionContinue
+	}
+
+	for _, f := range expired {
+		volid := fmt.Sprintf("%s:vztmpl/%s", c.TemplateStoragePool, f.Name)
+		switch {
+		case inUse[f.Name]:
+			ui.Message(fmt.Sprintf("Keeping %s, a container is being created from it", volid))
+		case c.RetentionDryRun:
+			ui.Message(fmt.Sprintf("Would delete %s (%s)", volid, f.CreationTime.Format(time.RFC3339)))
+		default:
+			ui.Message(fmt.Sprintf("Deleting %s (%s)", volid, f.CreationTime.Format(time.RFC3339)))
+			if err := deleteStoredTemplate(client, c, f.Name); err != nil {
+				ui.Error(fmt.Sprintf("Error deleting %s: %s", volid, err))
+			}
+		}
+	}
+
+	return multistep.ActionContinue
+}
+
+func (s *stepRetention) Cleanup(state multistep.StateBag) {}
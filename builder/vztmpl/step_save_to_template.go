@@ -2,15 +2,16 @@ package vztmpl
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
-	"strconv"
+	"regexp"
 	"strings"
 
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
-	"github.com/pkg/sftp"
-	"golang.org/x/crypto/ssh"
 
 	"github.com/Telmate/proxmox-api-go/proxmox"
 	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
@@ -28,58 +29,110 @@ func (s *stepSaveToTemplate) Run(ctx context.Context, state multistep.StateBag)
 	client := state.Get("proxmoxClient").(*proxmox.Client)
 	vmRef := state.Get("vmRef").(*proxmox.VmRef)
 
-	backupSrcPath := state.Get("backupSrcPath").(string)
+	archivePath := state.Get("templateArchive").(string)
 	extension := state.Get("extension").(string)
 
-	user, err := proxmox.NewUserID(c.Username)
+	templateDstName := state.Get("templateName").(string) + "." + extension
+
+	ui.Say("Checking template archive")
+	checksum, err := checkTemplateFile(archivePath)
 	if err != nil {
-		err := fmt.Errorf("error parsing username: %s", err)
+		err := fmt.Errorf("error checking template archive: %s", err)
 		state.Put("error", err)
 		ui.Error(err.Error())
 		return multistep.ActionHalt
 	}
 
-	// Define new name
-	baseName := fileNameWithoutExtension(c.TemplateFile)
-	templateDstName := fmt.Sprintf("%s_%s.%s", baseName, c.TemplateSuffix, extension)
+	// Without output_directory, stepExportS3 sets templatePath to the
+	// exported object
+	if c.SkipUpload {
+		if c.OutputDirectory != "" {
+			ui.Say(fmt.Sprintf("Skipping template upload, it is kept in %s", c.OutputDirectory))
+			state.Put("templatePath", filepath.Join(c.OutputDirectory, templateDstName))
+		} else {
+			ui.Say(fmt.Sprintf("Skipping template upload, it is exported to s3://%s", c.S3Bucket))
+		}
+		deleteContainer(ui, client, vmRef)
+		return multistep.ActionContinue
+	}
 
-	ui.Say(fmt.Sprintf("Establishing SSH connection at [%s] to get backup...", c.proxmoxURL.Hostname()))
-	SftpClient, err := ConnectSFTP(ui, user.Name, c.Password, c.proxmoxURL.Hostname(), 22)
+	// Overwriting uploads under a temporary name, renamed over the existing
+	// template once verified
+	uploadName := templateDstName
+	if c.OnExistingTemplate == "overwrite" {
+		uploadName = fmt.Sprintf("packer-%d-%s", c.VMID, templateDstName)
+	}
+
+	ui.Say(fmt.Sprintf("Upload template %s to %s...", templateDstName, c.TemplateStoragePool))
+	r, err := os.Open(archivePath)
 	if err != nil {
 		state.Put("error", err)
 		ui.Error(err.Error())
 		return multistep.ActionHalt
 	}
-
-	ui.Say(fmt.Sprintf("Establishing SSH connection at [%s] to get backup...Done", c.proxmoxURL.Hostname()))
-	// open an SFTP session over an existing ssh connection.
-	err = uploadBackup(*client, ui, SftpClient, c.VMID, c.Node, backupSrcPath, c.TemplateStoragePool, templateDstName)
-	if err != nil {
+	defer r.Close()
+	if err := client.Upload(c.Node, c.TemplateStoragePool, "vztmpl", uploadName, r); err != nil {
+		if uploadName != templateDstName {
+			deleteStoredTemplate(client, c, uploadName)
+		}
+		err := fmt.Errorf("error uploading template: %s", err)
 		state.Put("error", err)
 		ui.Error(err.Error())
 		return multistep.ActionHalt
 	}
 
-	defer SftpClient.Close()
-
-	ui.Say("Finished. Deleting Backup File")
-	err = SftpClient.Remove(backupSrcPath)
+	ui.Say("Verifying uploaded template")
+	stored, err := storedTemplateChecksum(client, c, c.TemplateStoragePool, uploadName)
+	if err == nil && stored != checksum {
+		err = fmt.Errorf("stored template checksum %s doesn't match the uploaded %s", stored, checksum)
+	}
 	if err != nil {
-		ui.Error(fmt.Sprintf("Error Backup. Please delete it manually: %s", err))
-
+		if uploadName != templateDstName {
+			deleteStoredTemplate(client, c, uploadName)
+		}
+		err := fmt.Errorf("error verifying uploaded template: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
 	}
-	ui.Say("Finished. Deleting Backup File...Done")
+	if uploadName != templateDstName {
+		if err := renameStoredTemplate(client, c, uploadName, templateDstName); err != nil {
+			deleteStoredTemplate(client, c, uploadName)
+			err := fmt.Errorf("error replacing template %s: %s", templateDstName, err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		stale, err := staleTemplateFiles(client, distributeTarget{Node: c.Node, Storage: c.TemplateStoragePool}, state.Get("templateName").(string), templateDstName)
+		if err != nil {
+			ui.Error(fmt.Sprintf("Error listing overwritten templates: %s", err))
+		}
+		for _, name := range stale {
+			ui.Message(fmt.Sprintf("Deleting overwritten template %s", name))
+			if err := deleteStoredTemplate(client, c, name); err != nil {
+				ui.Error(fmt.Sprintf("Error deleting template %s. Please delete it manually: %s", name, err))
+			}
+		}
+	}
+	ui.Message(fmt.Sprintf("Template digest: sha256:%s", checksum))
+	state.Put("templateDigest", "sha256:"+checksum)
+	generatedData := state.Get("generated_data").(map[string]interface{})
+	generatedData["TemplateDigest"] = "sha256:" + checksum
+
+	deleteContainer(ui, client, vmRef)
+	state.Put("templatePath", templateDstName)
 
+	return multistep.ActionContinue
+}
+
+// deleteContainer deletes the container the template was made from.
+func deleteContainer(ui packersdk.Ui, client *proxmox.Client, vmRef *proxmox.VmRef) {
 	ui.Say("Finished. Deleting LXC Container")
-	_, err = client.DeleteVm(vmRef)
+	_, err := client.DeleteVm(vmRef)
 	if err != nil {
 		ui.Error(fmt.Sprintf("Error deleting VM. Please delete it manually: %s", err))
 	}
 	ui.Say("Finished. Deleting LXC Container... Done")
-
-	state.Put("templatePath", templateDstName)
-
-	return multistep.ActionContinue
 }
 
 func (s *stepSaveToTemplate) Cleanup(state multistep.StateBag) {
@@ -110,67 +163,52 @@ func (s *stepSaveToTemplate) Cleanup(state multistep.StateBag) {
 
 }
 
-func ConnectSFTP(ui packersdk.Ui, apiUser string, apiPassword string, apiAddr string, apiPort int) (*sftp.Client, error) {
-
-	config := &ssh.ClientConfig{
-		User: apiUser,
-		Auth: []ssh.AuthMethod{
-			ssh.Password(apiPassword),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-	}
-
-	var sshAddr string = apiAddr + ":" + strconv.Itoa(apiPort)
-
-	client, _ := ssh.Dial("tcp", sshAddr, config)
-	ftpClient, err := sftp.NewClient(client)
-	if err != nil {
-		return nil, err
-	}
-
-	return ftpClient, nil
-}
-
-func uploadBackup(prox_client proxmox.Client, ui packersdk.Ui, ftpClient *sftp.Client, vmId int, node string, srcFilePath string, templateStoragePool string, templateDstName string) error {
+var archiveExtensionRegexp = regexp.MustCompile(`(\.tar(\.\w+)?|\.tgz)$`)
 
-	ui.Say(fmt.Sprintf("Opening vzdump template backup %s ...", srcFilePath))
-	srcFile, err := ftpClient.Open(srcFilePath)
+// checkTemplateFile checks the template archive at path with
+// checkTemplateArchive, and returns its sha256 checksum computed in the same
+// pass.
+func checkTemplateFile(path string) (string, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		return err
+		return "", err
 	}
-	defer srcFile.Close()
+	defer f.Close()
 
-	ui.Say("Creating temp file to store backup file ...")
-	dstFile, err := os.CreateTemp("", "vztmpl")
-	if err != nil {
-		return err
+	h := sha256.New()
+	r := io.TeeReader(f, h)
+	if err := checkTemplateArchive(r); err != nil {
+		return "", err
 	}
-
-	ui.Say("Transferring vzdump data template to local path...")
-	// write to file
-	if _, err := dstFile.ReadFrom(srcFile); err != nil {
-		return err
-	}
-	defer os.Remove(dstFile.Name())
-
-	ui.Say(fmt.Sprintf("Upload template %s to %s...", templateDstName, templateStoragePool))
-	isoPath, _ := filepath.EvalSymlinks(dstFile.Name())
-	r, err := os.Open(isoPath)
-	if err != nil {
-		return err
-	}
-	if err := prox_client.Upload(node, templateStoragePool, "vztmpl", templateDstName, r); err != nil {
-		return err
+	// The decompressor may stop before the end of the file
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return "", err
 	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
 
-	return nil
+// templateBaseName returns the name the saved template is named after: the base
+// template file, the cloned container or the restored backup.
+func templateBaseName(c *Config) string {
+	switch {
+	case c.TemplateFile != "":
+		return fileNameWithoutExtension(c.TemplateFile)
+	case c.CloneName != "":
+		return c.CloneName
+	case c.RestoreFromBackup != "":
+		return fileNameWithoutExtension(c.RestoreFromBackup)
+	case c.RestoreFromVMID != 0:
+		return fmt.Sprintf("ct%d", c.RestoreFromVMID)
+	}
+	return fmt.Sprintf("ct%d", c.CloneVMID)
 }
 
+// fileNameWithoutExtension returns the base name of a file, without its
+// archive extension, e.g. `.tar.zst`, or its last extension.
 func fileNameWithoutExtension(fileName string) string {
-	i := 0
-	for i < 2 {
-		fileName = strings.TrimSuffix(filepath.Base(fileName), filepath.Ext(fileName))
-		i = i + 1
+	fileName = filepath.Base(fileName)
+	if loc := archiveExtensionRegexp.FindStringIndex(fileName); loc != nil {
+		return fileName[:loc[0]]
 	}
-	return fileName
+	return strings.TrimSuffix(fileName, filepath.Ext(fileName))
 }
@@ -0,0 +1,130 @@
+package vztmpl
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/Telmate/proxmox-api-go/proxmox"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// stepSDNVNet checks the SDN VNet the container is attached to, or creates an
+// ephemeral one when sdn_ephemeral_vnet is set.
+//
+// It sets sdn_vnet when it is generated.
+type stepSDNVNet struct {
+	created bool
+}
+
+func (s *stepSDNVNet) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	c := state.Get("config").(*Config)
+	client := state.Get("proxmoxClient").(*proxmox.Client)
+
+	if c.SDNVNet == "" && !c.SDNEphemeralVNet {
+		return multistep.ActionContinue
+	}
+
+	if c.SDNZone != "" {
+		if _, err := client.GetItemConfigMapStringInterface("/cluster/sdn/zones/"+c.SDNZone, "sdn zone", "CONFIG"); err != nil {
+			err := fmt.Errorf("error finding SDN zone %s: %s", c.SDNZone, err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+	}
+
+	if !c.SDNEphemeralVNet {
+		ui.Say(fmt.Sprintf("Checking SDN VNet %s", c.SDNVNet))
+		vnet, err := client.GetItemConfigMapStringInterface("/cluster/sdn/vnets/"+c.SDNVNet, "sdn vnet", "CONFIG")
+		if err != nil {
+			err := fmt.Errorf("error finding SDN VNet %s: %s", c.SDNVNet, err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		if zone, _ := vnet["zone"].(string); c.SDNZone != "" && zone != c.SDNZone {
+			err := fmt.Errorf("SDN VNet %s is in zone %s, not in %s", c.SDNVNet, zone, c.SDNZone)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		return multistep.ActionContinue
+	}
+
+	if c.SDNVNet == "" {
+		// VNet names are limited to 8 characters
+		b := make([]byte, 3)
+		if _, err := rand.Read(b); err != nil {
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		c.SDNVNet = "pkr" + hex.EncodeToString(b)[:5]
+	}
+
+	ui.Say(fmt.Sprintf("Creating SDN VNet %s in zone %s", c.SDNVNet, c.SDNZone))
+	vnet := map[string]interface{}{
+		"vnet": c.SDNVNet,
+		"zone": c.SDNZone,
+	}
+	if err := client.Post(vnet, "/cluster/sdn/vnets"); err != nil {
+		err := fmt.Errorf("error creating SDN VNet: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	s.created = true
+
+	subnet := map[string]interface{}{
+		"subnet": c.SDNSubnet,
+		"type":   "subnet",
+	}
+	if c.ProvisionGatewayIP != "" {
+		subnet["gateway"] = c.ProvisionGatewayIP
+	}
+	if err := client.Post(subnet, fmt.Sprintf("/cluster/sdn/vnets/%s/subnets", c.SDNVNet)); err != nil {
+		err := fmt.Errorf("error creating SDN subnet: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	ui.Message("Applying SDN configuration")
+	if _, err := client.PutWithTask(map[string]interface{}{}, "/cluster/sdn"); err != nil {
+		err := fmt.Errorf("error applying SDN configuration: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	return multistep.ActionContinue
+}
+
+func (s *stepSDNVNet) Cleanup(state multistep.StateBag) {
+	if !s.created {
+		return
+	}
+	ui := state.Get("ui").(packersdk.Ui)
+	c := state.Get("config").(*Config)
+	client := state.Get("proxmoxClient").(*proxmox.Client)
+
+	ui.Say(fmt.Sprintf("Deleting SDN VNet %s", c.SDNVNet))
+	// Subnets are identified by zone and CIDR, with the prefix length after a dash
+	subnetID := c.SDNZone + "-" + strings.Replace(c.SDNSubnet, "/", "-", 1)
+	url := fmt.Sprintf("/cluster/sdn/vnets/%s/subnets/%s", c.SDNVNet, subnetID)
+	if err := client.Delete(url); err != nil {
+		ui.Error(fmt.Sprintf("Error deleting SDN subnet. Please delete it manually: %s", err))
+	}
+	if err := client.Delete("/cluster/sdn/vnets/" + c.SDNVNet); err != nil {
+		ui.Error(fmt.Sprintf("Error deleting SDN VNet. Please delete it manually: %s", err))
+		return
+	}
+	if _, err := client.PutWithTask(map[string]interface{}{}, "/cluster/sdn"); err != nil {
+		ui.Error(fmt.Sprintf("Error applying SDN configuration. Please apply it manually: %s", err))
+	}
+}
@@ -0,0 +1,115 @@
+package vztmpl
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/communicator"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"golang.org/x/crypto/ssh"
+)
+
+// stepSSHHostKeys reads the SSH host public keys of the container through pct
+// exec on the node, so the SSH communicator only trusts those.
+//
+// It sets the sshHostKeys state used by sshConfigWithHostKeys.
+type stepSSHHostKeys struct{}
+
+func (s *stepSSHHostKeys) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	c := state.Get("config").(*Config)
+
+	if !c.PinSSHHostKeys || c.Comm.Type != "ssh" {
+		return multistep.ActionContinue
+	}
+
+	ui.Say("Reading SSH host keys of LXC Container")
+	sshClient, err := newNodeSSHClient(c)
+	if err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	defer sshClient.Close()
+
+	// Keys may still be generated while the container boots
+	var keys []ssh.PublicKey
+	for n := 0; n < 10; n++ {
+		var out string
+		out, err = runContainerCommand(sshClient, c.VMID, "cat /etc/ssh/ssh_host_*_key.pub")
+		if err == nil {
+			keys, err = parseHostKeys([]byte(out))
+		}
+		if err == nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return multistep.ActionHalt
+		case <-time.After(3 * time.Second):
+		}
+	}
+	if err != nil {
+		err := fmt.Errorf("error reading SSH host keys: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	for _, key := range keys {
+		ui.Message(fmt.Sprintf("Pinned %s host key %s", key.Type(), ssh.FingerprintSHA256(key)))
+	}
+	state.Put("sshHostKeys", keys)
+
+	return multistep.ActionContinue
+}
+
+func (s *stepSSHHostKeys) Cleanup(state multistep.StateBag) {}
+
+// parseHostKeys parses the content of one or more OpenSSH public key files.
+func parseHostKeys(in []byte) ([]ssh.PublicKey, error) {
+	var keys []ssh.PublicKey
+	for len(bytes.TrimSpace(in)) > 0 {
+		key, _, _, rest, err := ssh.ParseAuthorizedKey(in)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+		in = rest
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no host key found")
+	}
+	return keys, nil
+}
+
+// sshConfigWithHostKeys wraps the communicator SSH configuration, replacing its
+// host key callback with one accepting only the keys pinned by stepSSHHostKeys.
+func sshConfigWithHostKeys(comm *communicator.Config) func(multistep.StateBag) (*ssh.ClientConfig, error) {
+	sshConfig := comm.SSHConfigFunc()
+	return func(state multistep.StateBag) (*ssh.ClientConfig, error) {
+		config, err := sshConfig(state)
+		if err != nil {
+			return nil, err
+		}
+
+		keysUntyped, ok := state.GetOk("sshHostKeys")
+		if !ok {
+			return config, nil
+		}
+		keys := keysUntyped.([]ssh.PublicKey)
+		config.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			for _, k := range keys {
+				if bytes.Equal(k.Marshal(), key.Marshal()) {
+					return nil
+				}
+			}
+			return fmt.Errorf("host key %s of %s is not one of the container keys", ssh.FingerprintSHA256(key), hostname)
+		}
+		return config, nil
+	}
+}
@@ -101,7 +101,8 @@ func (s *StepSshKeyPair) Run(ctx context.Context, state multistep.StateBag) mult
 
 func (s *StepSshKeyPair) Cleanup(state multistep.StateBag) {
 	if s.Debug {
-		if err := os.Remove(s.DebugKeyPath); err != nil {
+		// The key is only written when an ephemeral one was created
+		if err := os.Remove(s.DebugKeyPath); err != nil && !os.IsNotExist(err) {
 			ui := state.Get("ui").(packersdk.Ui)
 			ui.Error(fmt.Sprintf(
 				"Error removing debug key '%s': %s", s.DebugKeyPath, err))
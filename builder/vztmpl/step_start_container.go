@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/Telmate/proxmox-api-go/proxmox"
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
@@ -37,14 +39,7 @@ func (s *stepStartContainer) Run(ctx context.Context, state multistep.StateBag)
 	}
 	config.SSHPublicKeys = string(c.Comm.SSHPublicKey)
 	config.Networks = proxmox.QemuDevices{
-		0: {
-			"bridge":   "vmbr0",
-			"name":     "eth0",
-			"gw":       c.ProvisionGatewayIP,
-			"ip":       c.ProvisionIP + "/24",
-			"firewall": 0,
-			"hwaddr":   c.ProvisionMac,
-		},
+		0: containerNetwork(c),
 	}
 
 	if c.Unprivileged {
@@ -78,7 +73,18 @@ func (s *stepStartContainer) Run(ctx context.Context, state multistep.StateBag)
 		vmRef.SetPool(c.Pool)
 	}
 
-	err := config.CreateLxc(vmRef, client)
+	isClone := c.CloneVMID != 0 || c.CloneName != ""
+	isRestore := c.RestoreFromBackup != "" || c.RestoreFromVMID != 0
+
+	var err error
+	switch {
+	case isClone:
+		err = cloneContainer(ui, client, c, vmRef)
+	case isRestore:
+		err = restoreContainer(ui, client, c, vmRef, config)
+	default:
+		err = config.CreateLxc(vmRef, client)
+	}
 	if err != nil {
 		state.Put("error", err)
 		ui.Error(err.Error())
@@ -101,9 +107,265 @@ func (s *stepStartContainer) Run(ctx context.Context, state multistep.StateBag)
 	// 	return multistep.ActionHalt
 	// }
 
+	if isClone || isRestore {
+		// Credentials can only be passed when creating from an ostemplate, so
+		// they have to be installed in the container once it runs
+		ui.Say("Installing communicator credentials in LXC Container")
+		err = installCredentials(c)
+		if err != nil {
+			err := fmt.Errorf("error installing credentials: %s", err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+	}
+
 	return multistep.ActionContinue
 }
 
+// containerNetwork returns the configuration of the provisioning network interface.
+func containerNetwork(c *Config) proxmox.QemuDevice {
+	network := proxmox.QemuDevice{
+		"bridge":   "vmbr0",
+		"name":     "eth0",
+		"firewall": 0,
+		"hwaddr":   c.ProvisionMac,
+	}
+	if c.SDNVNet != "" {
+		network["bridge"] = c.SDNVNet
+	}
+	if c.Firewall {
+		network["firewall"] = 1
+	}
+	// The interface is left unconfigured when the communicator doesn't need it
+	if c.ProvisionIP != "" {
+		ip := c.ProvisionIP
+		if !strings.Contains(ip, "/") {
+			ip += "/24"
+		}
+		network["ip"] = ip
+	}
+	if c.ProvisionGatewayIP != "" {
+		network["gw"] = c.ProvisionGatewayIP
+	}
+	if c.ProvisionIP6 != "" {
+		network["ip6"] = c.ProvisionIP6
+	}
+	if c.ProvisionGatewayIP6 != "" {
+		network["gw6"] = c.ProvisionGatewayIP6
+	}
+	return network
+}
+
+// formatNetworkParam formats a network device as the comma separated list of
+// key=value pairs expected by the API.
+func formatNetworkParam(device proxmox.QemuDevice) string {
+	keys := make([]string, 0, len(device))
+	for k := range device {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	params := make([]string, 0, len(keys))
+	for _, k := range keys {
+		params = append(params, fmt.Sprintf("%s=%v", k, device[k]))
+	}
+	return strings.Join(params, ",")
+}
+
+// cloneContainer creates the build container as a full clone of clone_vmid and
+// applies the build resources and network to it.
+func cloneContainer(ui packersdk.Ui, client *proxmox.Client, c *Config, vmRef *proxmox.VmRef) error {
+	if c.CloneName != "" {
+		vmRefs, err := client.GetVmRefsByName(c.CloneName)
+		if err != nil {
+			return fmt.Errorf("error finding container %s to clone: %s", c.CloneName, err)
+		}
+		var ids []int
+		for _, ref := range vmRefs {
+			if ref.GetVmType() == "lxc" {
+				ids = append(ids, ref.VmId())
+			}
+		}
+		if len(ids) != 1 {
+			return fmt.Errorf("expected exactly one container named %s to clone, found %d", c.CloneName, len(ids))
+		}
+		c.CloneVMID = ids[0]
+	}
+
+	source := proxmox.NewVmRef(c.CloneVMID)
+	if err := client.CheckVmRef(source); err != nil {
+		return fmt.Errorf("error finding container %d to clone: %s", c.CloneVMID, err)
+	}
+	if source.Node() != c.Node {
+		return fmt.Errorf("container %d to clone is on node %s, not on %s", c.CloneVMID, source.Node(), c.Node)
+	}
+
+	sourceConfig, err := client.GetVmConfig(source)
+	if err != nil {
+		return fmt.Errorf("error reading configuration of container %d: %s", c.CloneVMID, err)
+	}
+	rootfs, _ := sourceConfig["rootfs"].(string)
+	resize, err := cloneNeedsResize(rootfs, c.FSSize)
+	if err != nil {
+		return fmt.Errorf("error cloning container %d: %s", c.CloneVMID, err)
+	}
+
+	ui.Say(fmt.Sprintf("Cloning LXC Container %d", c.CloneVMID))
+	config := proxmox.NewConfigLxc()
+	config.Clone = strconv.Itoa(c.CloneVMID)
+	config.Full = true
+	config.CloneStorage = c.FSStorage
+	config.Snapname = c.CloneSnapshot
+	config.Pool = c.Pool
+	if err := config.CloneLxc(vmRef, client); err != nil {
+		return err
+	}
+
+	params := map[string]interface{}{
+		"memory": c.Memory,
+		"cores":  c.Cores,
+		"net0":   formatNetworkParam(containerNetwork(c)),
+	}
+	if _, err := client.SetLxcConfig(vmRef, params); err != nil {
+		return fmt.Errorf("error configuring cloned container: %s", err)
+	}
+
+	if resize {
+		url := fmt.Sprintf("/nodes/%s/lxc/%d/resize", vmRef.Node(), vmRef.VmId())
+		params := map[string]interface{}{
+			"disk": "rootfs",
+			"size": strconv.Itoa(c.FSSize) + "G",
+		}
+		if _, err := client.PutWithTask(params, url); err != nil {
+			return fmt.Errorf("error resizing root disk of cloned container: %s", err)
+		}
+	}
+	return nil
+}
+
+// cloneNeedsResize reports whether the root disk of a clone of a container
+// with the given rootfs option must be grown to filesystem_size, in GiB. Root
+// disks can't be shrunk.
+func cloneNeedsResize(rootfs string, fsSize int) (bool, error) {
+	sourceSize, err := rootfsSize(rootfs)
+	if err != nil {
+		return false, fmt.Errorf("error reading root disk size: %s", err)
+	}
+	size := int64(fsSize) << 30
+	if size < sourceSize {
+		return false, fmt.Errorf("filesystem_size %dG is smaller than the %.1fG root disk, which can't be shrunk", fsSize, float64(sourceSize)/(1<<30))
+	}
+	return size > sourceSize, nil
+}
+
+// rootfsSize returns the size in bytes of a rootfs option of a container
+// configuration, e.g. `local-lvm:vm-100-disk-0,size=8G`.
+func rootfsSize(rootfs string) (int64, error) {
+	for _, option := range strings.Split(rootfs, ",") {
+		value := strings.TrimPrefix(option, "size=")
+		if value == option || value == "" {
+			continue
+		}
+		shift := 0
+		switch value[len(value)-1] {
+		case 'K':
+			shift = 10
+		case 'M':
+			shift = 20
+		case 'G':
+			shift = 30
+		case 'T':
+			shift = 40
+		}
+		if shift > 0 {
+			value = value[:len(value)-1]
+		}
+		size, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid size in %q", rootfs)
+		}
+		return int64(size * float64(int64(1)<<shift)), nil
+	}
+	return 0, fmt.Errorf("no size in %q", rootfs)
+}
+
+// restoreContainer creates the build container from restore_from_backup, or from
+// the latest backup of restore_from_vmid, the way pct restore does.
+func restoreContainer(ui packersdk.Ui, client *proxmox.Client, c *Config, vmRef *proxmox.VmRef, config proxmox.ConfigLxc) error {
+	volid := c.RestoreFromBackup
+	if volid == "" {
+		var err error
+		volid, err = latestBackupVolid(client, c.Node, c.BackupStoragePool, c.RestoreFromVMID)
+		if err != nil {
+			return fmt.Errorf("error finding latest backup of %d: %s", c.RestoreFromVMID, err)
+		}
+	}
+
+	ui.Say(fmt.Sprintf("Restoring LXC Container from %s", volid))
+	config.Ostemplate = volid
+	config.Restore = true
+	config.Storage = c.FSStorage
+	config.Password = ""
+	config.SSHPublicKeys = ""
+	return config.CreateLxc(vmRef, client)
+}
+
+// latestBackupVolid returns the volume ID of the most recent backup of the
+// container vmId in the given storage.
+func latestBackupVolid(client *proxmox.Client, node string, storage string, vmId int) (string, error) {
+	files, err := proxmox.ListFiles(client, node, storage, proxmox.ContentType_Backup)
+	if err != nil {
+		return "", err
+	}
+
+	prefix := fmt.Sprintf("vzdump-lxc-%d-", vmId)
+	var backups []proxmox.Content_FileProperties
+	for _, file := range *files {
+		if strings.HasPrefix(file.Name, prefix) {
+			backups = append(backups, file)
+		}
+	}
+	if len(backups) == 0 {
+		return "", fmt.Errorf("no backup found in %s", storage)
+	}
+	sort.Sort(ByCreationTime(backups))
+
+	return fmt.Sprintf("%s:%s/%s", storage, proxmox.ContentType_Backup, backups[0].Name), nil
+}
+
+// installCredentials sets up the communicator public key and password inside
+// the running container.
+func installCredentials(c *Config) error {
+	sshClient, err := newNodeSSHClient(c)
+	if err != nil {
+		return err
+	}
+	defer sshClient.Close()
+
+	user := c.Comm.SSHUsername
+	if user == "" {
+		user = "root"
+	}
+	if len(c.Comm.SSHPublicKey) > 0 {
+		script := fmt.Sprintf(`home=$(getent passwd %[1]s | cut -d: -f6) && `+
+			`mkdir -p "$home/.ssh" && echo %[2]s >> "$home/.ssh/authorized_keys" && `+
+			`chmod 700 "$home/.ssh" && chmod 600 "$home/.ssh/authorized_keys" && `+
+			`chown -R %[1]s "$home/.ssh"`,
+			shellQuote(user), shellQuote(strings.TrimSpace(string(c.Comm.SSHPublicKey))))
+		if _, err := runContainerCommand(sshClient, c.VMID, script); err != nil {
+			return err
+		}
+	}
+	if c.Comm.SSHPassword != "" {
+		script := fmt.Sprintf("echo %s | chpasswd", shellQuote(user+":"+c.Comm.SSHPassword))
+		if _, err := runContainerCommand(sshClient, c.VMID, script); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 type startedVMCleaner interface {
 	CheckVmRef(vmRef *proxmox.VmRef) (err error)
 	StopVm(*proxmox.VmRef) (string, error)
@@ -0,0 +1,33 @@
+package vztmpl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRootfsSize(t *testing.T) {
+	size, err := rootfsSize("local-lvm:vm-100-disk-0,size=8G")
+	require.NoError(t, err)
+	require.Equal(t, int64(8)<<30, size)
+
+	size, err = rootfsSize("local:100/vm-100-disk-0.raw,mountoptions=noatime,size=512M")
+	require.NoError(t, err)
+	require.Equal(t, int64(512)<<20, size)
+
+	_, err = rootfsSize("local-lvm:vm-100-disk-0")
+	require.Error(t, err)
+}
+
+func TestCloneNeedsResize(t *testing.T) {
+	resize, err := cloneNeedsResize("local-lvm:vm-100-disk-0,size=8G", 8)
+	require.NoError(t, err)
+	require.False(t, resize)
+
+	resize, err = cloneNeedsResize("local-lvm:vm-100-disk-0,size=8G", 16)
+	require.NoError(t, err)
+	require.True(t, resize)
+
+	_, err = cloneNeedsResize("local-lvm:vm-100-disk-0,size=8G", 4)
+	require.ErrorContains(t, err, "can't be shrunk")
+}
@@ -0,0 +1,188 @@
+package vztmpl
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/Telmate/proxmox-api-go/proxmox"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/template/interpolate"
+)
+
+type templateNameData struct {
+	BuildName string
+	VMID      int
+	BaseName  string
+	OS        string
+	OSVersion string
+	Arch      string
+}
+
+// stepTemplateName computes the name the template is saved as, reading the OS
+// and architecture of the container when template_name or
+// template_appliance_name needs them.
+//
+// The name is checked against the templates already in storage, following
+// on_existing_template, before the long export starts.
+//
+// It sets the templateName state, without extension, and the
+// templateNameMatcher state matching the names of the templates of the build.
+type stepTemplateName struct{}
+
+func (s *stepTemplateName) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	c := state.Get("config").(*Config)
+	client := state.Get("proxmoxClient").(*proxmox.Client)
+	vmRef := state.Get("vmRef").(*proxmox.VmRef)
+
+	data := &templateNameData{
+		BuildName: c.PackerBuildName,
+		VMID:      c.VMID,
+		BaseName:  templateBaseName(c),
+	}
+	if c.TemplateName != "" || c.TemplateApplianceName {
+		if err := readContainerOS(client, c, vmRef, data); err != nil {
+			err := fmt.Errorf("error reading container OS: %s", err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+	}
+
+	name, err := templateName(c, data)
+	if err != nil {
+		err := fmt.Errorf("error naming template: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	match := templateNameMatcher(c, data, name)
+
+	stored := map[string][]distributeTarget{}
+	if !c.SkipUpload {
+		stored, err = storedTemplateNames(client, c)
+	}
+	if err != nil {
+		err := fmt.Errorf("error listing templates: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	if targets := stored[name]; len(targets) > 0 {
+		switch c.OnExistingTemplate {
+		case "fail":
+			err := fmt.Errorf("template %s already exists in %s", name, joinTargets(targets))
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		case "version":
+			ui.Message(fmt.Sprintf("Template %s already exists in %s", name, joinTargets(targets)))
+			taken := make(map[string]bool)
+			for n := range stored {
+				taken[n] = true
+			}
+			name = versionedTemplateName(name, taken)
+		case "overwrite":
+			ui.Message(fmt.Sprintf("Template %s already exists in %s and will be overwritten", name, joinTargets(targets)))
+		}
+	}
+
+	ui.Say(fmt.Sprintf("Template will be saved as %s", name))
+	state.Put("templateName", name)
+	state.Put("templateNameMatcher", match)
+
+	return multistep.ActionContinue
+}
+
+func (s *stepTemplateName) Cleanup(state multistep.StateBag) {}
+
+// readContainerOS fills the OS, version and architecture of the container.
+func readContainerOS(client *proxmox.Client, c *Config, vmRef *proxmox.VmRef, data *templateNameData) error {
+	config, err := client.GetVmConfig(vmRef)
+	if err != nil {
+		return err
+	}
+	data.Arch, _ = config["arch"].(string)
+	if data.Arch == "" {
+		data.Arch = "amd64"
+	}
+
+	sshClient, err := newNodeSSHClient(c)
+	if err != nil {
+		return err
+	}
+	defer sshClient.Close()
+
+	out, err := runContainerCommand(sshClient, c.VMID, `. /etc/os-release && echo "$ID" "$VERSION_ID"`)
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(out)
+	if len(fields) > 0 {
+		data.OS = fields[0]
+	}
+	if len(fields) > 1 {
+		data.OSVersion = fields[1]
+	}
+	return nil
+}
+
+// templateName returns the name of the template, without extension.
+func templateName(c *Config, data *templateNameData) (string, error) {
+	var name string
+	switch {
+	case c.TemplateName != "":
+		c.ctx.Data = data
+		var err error
+		if name, err = interpolate.Render(c.TemplateName, &c.ctx); err != nil {
+			return "", err
+		}
+	case c.TemplateApplianceName:
+		if data.OS == "" || data.OSVersion == "" {
+			return "", fmt.Errorf("ID and VERSION_ID are required in /etc/os-release")
+		}
+		name = applianceTemplate{
+			OS:      data.OS,
+			Version: data.OSVersion,
+			Name:    c.TemplateSuffix,
+			Release: c.TemplateRelease,
+			Arch:    data.Arch,
+		}.baseName()
+	default:
+		name = fmt.Sprintf("%s_%s", data.BaseName, c.TemplateSuffix)
+	}
+
+	if name == "" || strings.ContainsAny(name, "/ \t\n") {
+		return "", fmt.Errorf("invalid template name %q", name)
+	}
+	return name, nil
+}
+
+// templateNameMatcher returns a function reporting whether a template name,
+// without extension, is one of the build, the previous ones included, given
+// its base name. It is nil when there's no way to tell.
+func templateNameMatcher(c *Config, data *templateNameData, name string) func(string) bool {
+	switch {
+	case c.RetentionPattern != "":
+		return func(n string) bool {
+			matched, _ := path.Match(c.RetentionPattern, n)
+			return matched
+		}
+	case c.TemplateName != "":
+		return nil
+	case c.TemplateApplianceName:
+		return func(n string) bool {
+			a, ok := parseApplianceTemplate(n + ".tar.gz")
+			return ok && a.OS == data.OS && a.Version == data.OSVersion && a.Name == c.TemplateSuffix && a.Arch == data.Arch
+		}
+	}
+	versioned := regexp.MustCompile(`^` + regexp.QuoteMeta(name) + `-[0-9]+$`)
+	return func(n string) bool {
+		return n == name || versioned.MatchString(n)
+	}
+}
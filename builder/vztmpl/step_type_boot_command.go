@@ -0,0 +1,237 @@
+package vztmpl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"time"
+	"unicode"
+
+	"github.com/hashicorp/packer-plugin-sdk/bootcommand"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/template/interpolate"
+	"golang.org/x/crypto/ssh"
+)
+
+type bootCommandTemplateData struct {
+	HTTPIP   string
+	HTTPPort int
+}
+
+// stepTypeBootCommand types boot_command into the container console, attached
+// with pct console over an SSH connection to the node.
+type stepTypeBootCommand struct{}
+
+func (s *stepTypeBootCommand) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	c := state.Get("config").(*Config)
+
+	if len(c.BootCommand) == 0 {
+		return multistep.ActionContinue
+	}
+
+	if c.BootWait > 0 {
+		ui.Say(fmt.Sprintf("Waiting %s for boot", c.BootWait))
+		select {
+		case <-time.After(c.BootWait):
+		case <-ctx.Done():
+			return multistep.ActionHalt
+		}
+	}
+
+	httpIP, err := httpServerIP(c)
+	if err != nil {
+		err := fmt.Errorf("error determining HTTP server address: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	httpIP = bracketHost(httpIP)
+	state.Put("http_ip", httpIP)
+	httpPort, _ := state.Get("http_port").(int)
+
+	c.ctx.Data = &bootCommandTemplateData{
+		HTTPIP:   httpIP,
+		HTTPPort: httpPort,
+	}
+	command, err := interpolate.Render(c.FlatBootCommand(), &c.ctx)
+	if err != nil {
+		err := fmt.Errorf("error preparing boot command: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	seq, err := bootcommand.GenerateExpressionSequence(command)
+	if err != nil {
+		err := fmt.Errorf("error generating boot command: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	sshClient, err := newNodeSSHClient(c)
+	if err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	defer sshClient.Close()
+
+	ui.Say("Typing the boot command on the container console")
+	if err := typeOnConsole(ctx, sshClient, c.VMID, c.BootKeyInterval, seq); err != nil {
+		err := fmt.Errorf("error typing boot command: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	return multistep.ActionContinue
+}
+
+func (s *stepTypeBootCommand) Cleanup(state multistep.StateBag) {}
+
+type bootCommandSequence interface {
+	Do(context.Context, bootcommand.BCDriver) error
+}
+
+// typeOnConsole attaches to the container console and types the sequence into it.
+func typeOnConsole(ctx context.Context, client *ssh.Client, vmid int, interval time.Duration, seq bootCommandSequence) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	if err := session.RequestPty("xterm", 25, 80, ssh.TerminalModes{ssh.ECHO: 0}); err != nil {
+		return err
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+	session.Stdout = log.Writer()
+	session.Stderr = log.Writer()
+
+	if err := session.Start(fmt.Sprintf("pct console %d", vmid)); err != nil {
+		return err
+	}
+
+	return seq.Do(ctx, newConsoleDriver(stdin, interval))
+}
+
+// consoleSpecials maps the boot command special keys to the sequences an xterm
+// sends for them.
+var consoleSpecials = map[string]string{
+	"enter":    "\r",
+	"return":   "\r",
+	"esc":      "\x1b",
+	"bs":       "\x7f",
+	"del":      "\x1b[3~",
+	"tab":      "\t",
+	"spacebar": " ",
+	"insert":   "\x1b[2~",
+	"home":     "\x1b[H",
+	"end":      "\x1b[F",
+	"pageup":   "\x1b[5~",
+	"pagedown": "\x1b[6~",
+	"up":       "\x1b[A",
+	"down":     "\x1b[B",
+	"right":    "\x1b[C",
+	"left":     "\x1b[D",
+	"f1":       "\x1bOP",
+	"f2":       "\x1bOQ",
+	"f3":       "\x1bOR",
+	"f4":       "\x1bOS",
+	"f5":       "\x1b[15~",
+	"f6":       "\x1b[17~",
+	"f7":       "\x1b[18~",
+	"f8":       "\x1b[19~",
+	"f9":       "\x1b[20~",
+	"f10":      "\x1b[21~",
+	"f11":      "\x1b[23~",
+	"f12":      "\x1b[24~",
+}
+
+// consoleDriver is a bootcommand.BCDriver writing keys to a terminal, turning
+// special keys and modifiers into the matching control sequences.
+type consoleDriver struct {
+	w        io.Writer
+	interval time.Duration
+
+	ctrl  bool
+	alt   bool
+	shift bool
+}
+
+var _ bootcommand.BCDriver = &consoleDriver{}
+
+func newConsoleDriver(w io.Writer, interval time.Duration) *consoleDriver {
+	if interval == 0 {
+		interval = 10 * time.Millisecond
+	}
+	return &consoleDriver{
+		w:        w,
+		interval: interval,
+	}
+}
+
+func (d *consoleDriver) SendKey(key rune, action bootcommand.KeyAction) error {
+	if action == bootcommand.KeyOff {
+		return nil
+	}
+
+	if d.shift {
+		key = unicode.ToUpper(key)
+	}
+	if d.ctrl {
+		switch {
+		case key >= 'a' && key <= 'z':
+			key = key - 'a' + 1
+		case key >= '@' && key <= '_':
+			key = key - '@'
+		}
+	}
+	return d.send(string(key))
+}
+
+func (d *consoleDriver) SendSpecial(special string, action bootcommand.KeyAction) error {
+	switch special {
+	case "leftctrl", "rightctrl":
+		d.ctrl = action == bootcommand.KeyOn || (d.ctrl && action == bootcommand.KeyPress)
+		return nil
+	case "leftalt", "rightalt":
+		d.alt = action == bootcommand.KeyOn || (d.alt && action == bootcommand.KeyPress)
+		return nil
+	case "leftshift", "rightshift":
+		d.shift = action == bootcommand.KeyOn || (d.shift && action == bootcommand.KeyPress)
+		return nil
+	case "leftsuper", "rightsuper":
+		return nil
+	}
+
+	sequence, ok := consoleSpecials[special]
+	if !ok {
+		return fmt.Errorf("special %s not supported on the container console", special)
+	}
+	if action == bootcommand.KeyOff {
+		return nil
+	}
+	return d.send(sequence)
+}
+
+func (d *consoleDriver) Flush() error {
+	return nil
+}
+
+func (d *consoleDriver) send(s string) error {
+	if d.alt {
+		s = "\x1b" + s
+	}
+	if _, err := io.WriteString(d.w, s); err != nil {
+		return err
+	}
+	time.Sleep(d.interval)
+	return nil
+}
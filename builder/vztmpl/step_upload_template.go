@@ -0,0 +1,181 @@
+package vztmpl
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/Telmate/proxmox-api-go/proxmox"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+var templateArchiveRegexp = regexp.MustCompile(`(\.tar\.(gz|xz|zst|bz2)|\.tgz)$`)
+
+// stepUploadTemplate uploads a base template from the Packer host when
+// template_file points to a local file.
+//
+// The upload is skipped when the template storage already holds a file with the
+// same name and sha256 checksum.
+type stepUploadTemplate struct{}
+
+func (s *stepUploadTemplate) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	c := state.Get("config").(*Config)
+	client := state.Get("proxmoxClient").(*proxmox.Client)
+
+	if c.templateLocalPath == "" {
+		return multistep.ActionContinue
+	}
+
+	ui.Say(fmt.Sprintf("Computing checksum of %s ...", c.templateLocalPath))
+	checksum, size, err := localTemplateChecksum(c.templateLocalPath, c.TemplateChecksum)
+	if err != nil {
+		err := fmt.Errorf("error reading template %s: %s", c.templateLocalPath, err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	unchanged, err := storedTemplateMatches(client, c, checksum, size)
+	if err != nil {
+		ui.Say(fmt.Sprintf("Could not compare with the stored template (%s), uploading it again", err))
+	}
+	if unchanged {
+		ui.Say(fmt.Sprintf("Template %s is unchanged in %s, skipping upload", c.TemplateFile, c.TemplateStoragePool))
+		return multistep.ActionContinue
+	}
+
+	r, err := os.Open(c.templateLocalPath)
+	if err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	defer r.Close()
+
+	ui.Say(fmt.Sprintf("Upload template %s to %s...", c.TemplateFile, c.TemplateStoragePool))
+	if err := client.Upload(c.Node, c.TemplateStoragePool, "vztmpl", c.TemplateFile, r); err != nil {
+		err := fmt.Errorf("error uploading template: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	return multistep.ActionContinue
+}
+
+func (s *stepUploadTemplate) Cleanup(state multistep.StateBag) {}
+
+// localTemplateChecksum returns the sha256 checksum and size of the file at
+// path, verifying it against expected when one is given.
+func localTemplateChecksum(path string, expected string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	writers := []io.Writer{h}
+
+	var algorithm, value string
+	if expected != "" && strings.ToLower(expected) != "none" {
+		algorithm, value, err = parseChecksum(expected)
+		if err != nil {
+			return "", 0, err
+		}
+	}
+	verify := newChecksumHash(algorithm)
+	if verify != nil {
+		writers = append(writers, verify)
+	}
+
+	size, err := io.Copy(io.MultiWriter(writers...), f)
+	if err != nil {
+		return "", 0, err
+	}
+
+	if verify != nil {
+		if actual := hex.EncodeToString(verify.Sum(nil)); actual != value {
+			return "", 0, fmt.Errorf("checksum mismatch: expected %s, got %s", value, actual)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+// storedTemplateMatches reports whether template_file is already in the template
+// storage with the given size and sha256 checksum.
+func storedTemplateMatches(client *proxmox.Client, c *Config, checksum string, size int64) (bool, error) {
+	files, err := proxmox.ListFiles(client, c.Node, c.TemplateStoragePool, proxmox.ContentType_Template)
+	if err != nil {
+		return false, err
+	}
+	found := false
+	for _, f := range *files {
+		if f.Name == c.TemplateFile && int64(f.Size) == size {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return false, nil
+	}
+
+	stored, err := storedTemplateChecksum(client, c, c.TemplateStoragePool, c.TemplateFile)
+	if err != nil {
+		return false, err
+	}
+	return stored == checksum, nil
+}
+
+// storedTemplateChecksum returns the sha256 checksum of a template in storage,
+// computed on the node.
+func storedTemplateChecksum(client *proxmox.Client, c *Config, storage string, name string) (string, error) {
+	volid := fmt.Sprintf("%s:vztmpl/%s", storage, name)
+	path, err := storageVolumePath(client, c.Node, storage, volid)
+	if err != nil {
+		return "", err
+	}
+
+	sshClient, err := newNodeSSHClient(c)
+	if err != nil {
+		return "", err
+	}
+	defer sshClient.Close()
+
+	out, err := runNodeCommand(sshClient, "sha256sum "+shellQuote(path))
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(out)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("no checksum returned for %s", volid)
+	}
+	return fields[0], nil
+}
+
+// findTemplateArchive returns the template archive inside dir, typically the
+// output directory of a previous Packer build.
+func findTemplateArchive(dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	var archives []string
+	for _, e := range entries {
+		if !e.IsDir() && templateArchiveRegexp.MatchString(e.Name()) {
+			archives = append(archives, filepath.Join(dir, e.Name()))
+		}
+	}
+	if len(archives) != 1 {
+		return "", fmt.Errorf("expected exactly one template archive in %s, found %d", dir, len(archives))
+	}
+	return archives[0], nil
+}
@@ -0,0 +1,122 @@
+package vztmpl
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Appliance templates follow the `<os>-<version>-<name>_<release>_<arch>.tar.<ext>`
+// naming convention, e.g. debian-11-standard_11.7-1_amd64.tar.zst.
+var applianceNameRegexp = regexp.MustCompile(`^([a-z]+)-([0-9][0-9.]*)-([^_]+)_([^_]+)_([^_.]+)\.tar\.(\w+)$`)
+
+// applianceTemplate is a template file name split into its naming convention parts.
+type applianceTemplate struct {
+	File    string
+	OS      string
+	Version string
+	Name    string
+	Release string
+	Arch    string
+
+	// InStorage is true when the file is already present in the template storage
+	InStorage bool
+}
+
+func parseApplianceTemplate(file string) (applianceTemplate, bool) {
+	m := applianceNameRegexp.FindStringSubmatch(file)
+	if m == nil {
+		return applianceTemplate{}, false
+	}
+	return applianceTemplate{
+		File:    file,
+		OS:      m[1],
+		Version: m[2],
+		Name:    m[3],
+		Release: m[4],
+		Arch:    m[5],
+	}, true
+}
+
+// baseName returns the file name of the template following the naming
+// convention, without extension.
+func (t applianceTemplate) baseName() string {
+	return fmt.Sprintf("%s-%s-%s_%s_%s", t.OS, t.Version, t.Name, t.Release, t.Arch)
+}
+
+// matches reports whether the template is for the given OS and version. An empty
+// version matches any version, and a version matches all of its point releases
+// ("22" matches "22.04").
+func (t applianceTemplate) matches(os string, version string) bool {
+	if t.OS != strings.ToLower(os) {
+		return false
+	}
+	if version == "" || t.Version == version {
+		return true
+	}
+	return strings.HasPrefix(t.Version, version+".")
+}
+
+// newestApplianceTemplate returns the newest template for the given OS and version.
+// When the same file is listed several times, the copy already in storage wins.
+func newestApplianceTemplate(files []string, storageFiles []string, os string, version string) (applianceTemplate, bool) {
+	var candidates []applianceTemplate
+	for _, f := range storageFiles {
+		if t, ok := parseApplianceTemplate(f); ok && t.matches(os, version) {
+			t.InStorage = true
+			candidates = append(candidates, t)
+		}
+	}
+	for _, f := range files {
+		if t, ok := parseApplianceTemplate(f); ok && t.matches(os, version) {
+			candidates = append(candidates, t)
+		}
+	}
+	if len(candidates) == 0 {
+		return applianceTemplate{}, false
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if c := compareVersions(candidates[i].Version, candidates[j].Version); c != 0 {
+			return c > 0
+		}
+		return compareVersions(candidates[i].Release, candidates[j].Release) > 0
+	})
+	return candidates[0], true
+}
+
+var versionPartRegexp = regexp.MustCompile(`[0-9]+|[^0-9.\-_]+`)
+
+// compareVersions compares two version strings part by part, numeric parts
+// numerically and other parts lexically. It returns -1, 0 or 1.
+func compareVersions(a string, b string) int {
+	pa := versionPartRegexp.FindAllString(a, -1)
+	pb := versionPartRegexp.FindAllString(b, -1)
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		na, errA := strconv.Atoi(pa[i])
+		nb, errB := strconv.Atoi(pb[i])
+		switch {
+		case errA == nil && errB == nil:
+			if na != nb {
+				if na > nb {
+					return 1
+				}
+				return -1
+			}
+		case pa[i] != pb[i]:
+			if pa[i] > pb[i] {
+				return 1
+			}
+			return -1
+		}
+	}
+	switch {
+	case len(pa) > len(pb):
+		return 1
+	case len(pa) < len(pb):
+		return -1
+	}
+	return 0
+}
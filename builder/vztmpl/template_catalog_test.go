@@ -0,0 +1,62 @@
+package vztmpl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseApplianceTemplate(t *testing.T) {
+	tmpl, ok := parseApplianceTemplate("debian-11-standard_11.7-1_amd64.tar.zst")
+	require.True(t, ok)
+	require.Equal(t, "debian", tmpl.OS)
+	require.Equal(t, "11", tmpl.Version)
+	require.Equal(t, "standard", tmpl.Name)
+	require.Equal(t, "11.7-1", tmpl.Release)
+	require.Equal(t, "amd64", tmpl.Arch)
+
+	_, ok = parseApplianceTemplate("debian-11-standard_11.3-1_amd64_custom.tar.gz")
+	require.False(t, ok)
+
+	require.Equal(t, "debian-11-standard_11.7-1_amd64", tmpl.baseName())
+}
+
+func TestNewestApplianceTemplate(t *testing.T) {
+	catalog := []string{
+		"debian-10-standard_10.7-1_amd64.tar.gz",
+		"debian-11-standard_11.7-1_amd64.tar.zst",
+		"debian-12-standard_12.0-1_amd64.tar.zst",
+		"ubuntu-22.04-standard_22.04-1_amd64.tar.zst",
+		"ubuntu-23.04-standard_23.04-1_amd64.tar.zst",
+		"turnkey-nextcloud_17.1-1_amd64.tar.gz",
+	}
+	storage := []string{
+		"debian-11-standard_11.3-1_amd64.tar.zst",
+		"debian-11-standard_11.7-1_amd64.tar.zst",
+		"debian-11-standard_11.3-1_amd64_custom.tar.gz",
+	}
+
+	tmpl, ok := newestApplianceTemplate(catalog, storage, "debian", "")
+	require.True(t, ok)
+	require.Equal(t, "debian-12-standard_12.0-1_amd64.tar.zst", tmpl.File)
+	require.False(t, tmpl.InStorage)
+
+	tmpl, ok = newestApplianceTemplate(catalog, storage, "debian", "11")
+	require.True(t, ok)
+	require.Equal(t, "debian-11-standard_11.7-1_amd64.tar.zst", tmpl.File)
+	require.True(t, tmpl.InStorage)
+
+	tmpl, ok = newestApplianceTemplate(catalog, storage, "ubuntu", "22")
+	require.True(t, ok)
+	require.Equal(t, "ubuntu-22.04-standard_22.04-1_amd64.tar.zst", tmpl.File)
+
+	_, ok = newestApplianceTemplate(catalog, storage, "centos", "")
+	require.False(t, ok)
+}
+
+func TestCompareVersions(t *testing.T) {
+	require.Equal(t, 1, compareVersions("11.10-1", "11.9-1"))
+	require.Equal(t, -1, compareVersions("22.04", "23.04"))
+	require.Equal(t, 0, compareVersions("3.18", "3.18"))
+	require.Equal(t, 1, compareVersions("20230607", "20230101"))
+}
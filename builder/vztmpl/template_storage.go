@@ -0,0 +1,237 @@
+package vztmpl
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Telmate/proxmox-api-go/proxmox"
+)
+
+// onExistingTemplatePolicies are the on_existing_template values.
+var onExistingTemplatePolicies = []string{"fail", "overwrite", "version"}
+
+// storedTemplateNames returns the names, without extension, of the templates
+// in template_storage_pool and the distribute_to storages, with the storages
+// holding them.
+func storedTemplateNames(client *proxmox.Client, c *Config) (map[string][]distributeTarget, error) {
+	names := make(map[string][]distributeTarget)
+	primary := distributeTarget{Node: c.Node, Storage: c.TemplateStoragePool}
+	for _, t := range append([]distributeTarget{primary}, c.distributeTargets...) {
+		files, err := proxmox.ListFiles(client, t.Node, t.Storage, proxmox.ContentType_Template)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", t, err)
+		}
+		for _, f := range *files {
+			name := fileNameWithoutExtension(f.Name)
+			names[name] = append(names[name], t)
+		}
+	}
+	return names, nil
+}
+
+var trailingNumberRegexp = regexp.MustCompile(`^(.*?)(\d+)$`)
+
+// nextRelease returns release with its trailing number incremented, or with
+// `.1` appended if it doesn't end with one.
+func nextRelease(release string) string {
+	m := trailingNumberRegexp.FindStringSubmatch(release)
+	if m == nil {
+		return release + ".1"
+	}
+	n, _ := strconv.Atoi(m[2])
+	return m[1] + strconv.Itoa(n+1)
+}
+
+// versionedTemplateName returns the first name, starting from name, that isn't
+// taken. Appliance names get their release incremented, other names a `-<n>`
+// suffix.
+func versionedTemplateName(name string, taken map[string]bool) string {
+	if !taken[name] {
+		return name
+	}
+	if a, ok := parseApplianceTemplate(name + ".tar.gz"); ok {
+		for taken[a.baseName()] {
+			a.Release = nextRelease(a.Release)
+		}
+		return a.baseName()
+	}
+	for n := 2; ; n++ {
+		candidate := fmt.Sprintf("%s-%d", name, n)
+		if !taken[candidate] {
+			return candidate
+		}
+	}
+}
+
+// renameStoredTemplate renames a template of template_storage_pool on the
+// node, replacing any template with the new name. Both live in the same
+// directory, so the replacement is atomic.
+func renameStoredTemplate(client *proxmox.Client, c *Config, name string, newName string) error {
+	volid := fmt.Sprintf("%s:vztmpl/%s", c.TemplateStoragePool, name)
+	src, err := storageVolumePath(client, c.Node, c.TemplateStoragePool, volid)
+	if err != nil {
+		return err
+	}
+	dst := path.Join(path.Dir(src), newName)
+
+	sshClient, err := newNodeSSHClient(c)
+	if err != nil {
+		return err
+	}
+	defer sshClient.Close()
+
+	_, err = runNodeCommand(sshClient, fmt.Sprintf("mv -f %s %s", shellQuote(src), shellQuote(dst)))
+	return err
+}
+
+// distributeTarget is a template storage of a node the template is copied to.
+type distributeTarget struct {
+	Node    string
+	Storage string
+}
+
+// parseDistributeTarget parses a distribute_to entry, `<node>:<storage>` or
+// `<storage>` for a storage of node.
+func parseDistributeTarget(s string, node string) (distributeTarget, error) {
+	parts := strings.Split(s, ":")
+	switch {
+	case len(parts) == 1 && parts[0] != "":
+		return distributeTarget{Node: node, Storage: parts[0]}, nil
+	case len(parts) == 2 && parts[0] != "" && parts[1] != "":
+		return distributeTarget{Node: parts[0], Storage: parts[1]}, nil
+	}
+	return distributeTarget{}, fmt.Errorf("%q must be <node>:<storage> or <storage>", s)
+}
+
+// String returns the target as `<node>:<storage>`.
+func (t distributeTarget) String() string {
+	return t.Node + ":" + t.Storage
+}
+
+// joinTargets returns the targets as a comma-separated list.
+func joinTargets(targets []distributeTarget) string {
+	names := make([]string, len(targets))
+	for i, t := range targets {
+		names[i] = t.String()
+	}
+	return strings.Join(names, ", ")
+}
+
+// volid returns the volume ID of the template name in the target storage,
+// prefixed with its node as in `<node>/<storage>:vztmpl/<name>`, since the
+// same storage name can be local to several nodes.
+func (t distributeTarget) volid(name string) string {
+	return fmt.Sprintf("%s/%s:vztmpl/%s", t.Node, t.Storage, name)
+}
+
+// checkDistributeTargets checks that no two of the targets, the template
+// storage primary included, are the same shared storage.
+func checkDistributeTargets(primary distributeTarget, targets []distributeTarget, shared map[distributeTarget]bool) error {
+	seen := make(map[string]distributeTarget)
+	for _, t := range append([]distributeTarget{primary}, targets...) {
+		if !shared[t] {
+			continue
+		}
+		if other, ok := seen[t.Storage]; ok {
+			return fmt.Errorf("%s and %s are the same shared storage", other, t)
+		}
+		seen[t.Storage] = t
+	}
+	return nil
+}
+
+// staleTemplateFiles returns the files of a template storage named name with
+// another extension than file.
+func staleTemplateFiles(client *proxmox.Client, target distributeTarget, name string, file string) ([]string, error) {
+	files, err := proxmox.ListFiles(client, target.Node, target.Storage, proxmox.ContentType_Template)
+	if err != nil {
+		return nil, err
+	}
+	var stale []string
+	for _, f := range *files {
+		if f.Name != file && fileNameWithoutExtension(f.Name) == name {
+			stale = append(stale, f.Name)
+		}
+	}
+	return stale, nil
+}
+
+// deleteStoredTemplate deletes a template of template_storage_pool.
+func deleteStoredTemplate(client *proxmox.Client, c *Config, name string) error {
+	return deleteTemplate(client, distributeTarget{Node: c.Node, Storage: c.TemplateStoragePool}, name)
+}
+
+// deleteTemplate deletes a template of a template storage.
+func deleteTemplate(client *proxmox.Client, target distributeTarget, name string) error {
+	volid := fmt.Sprintf("%s:vztmpl/%s", target.Storage, name)
+	_, err := client.DeleteWithTask(fmt.Sprintf("/nodes/%s/storage/%s/content/%s", target.Node, target.Storage, volid))
+	return err
+}
+
+// expiredTemplates returns the templates among files whose name matches that
+// are neither among the keepLast newest nor younger than keepDays, from the
+// newest to the oldest. A zero keepLast or keepDays doesn't keep anything by
+// itself.
+func expiredTemplates(files []proxmox.Content_FileProperties, match func(string) bool, keepLast int, keepDays int, now time.Time) []proxmox.Content_FileProperties {
+	var matching []proxmox.Content_FileProperties
+	for _, f := range files {
+		if match(fileNameWithoutExtension(f.Name)) {
+			matching = append(matching, f)
+		}
+	}
+	sort.SliceStable(matching, func(i, j int) bool {
+		return matching[i].CreationTime.After(matching[j].CreationTime)
+	})
+
+	cutoff := now.AddDate(0, 0, -keepDays)
+	var expired []proxmox.Content_FileProperties
+	for i, f := range matching {
+		if i < keepLast || (keepDays > 0 && f.CreationTime.After(cutoff)) {
+			continue
+		}
+		expired = append(expired, f)
+	}
+	return expired
+}
+
+// templatesInUse returns the names of the templates among files that a
+// running container creation or restore, a `vzcreate` task of the cluster,
+// extracts. Proxmox doesn't record the template of existing containers, so
+// they can't be checked.
+func templatesInUse(client *proxmox.Client, files []proxmox.Content_FileProperties) (map[string]bool, error) {
+	tasks, err := client.GetItemListInterfaceArray("/cluster/tasks")
+	if err != nil {
+		return nil, err
+	}
+
+	inUse := make(map[string]bool)
+	for _, t := range tasks {
+		task, _ := t.(map[string]interface{})
+		taskType, _ := task["type"].(string)
+		if _, finished := task["endtime"]; taskType != "vzcreate" || finished {
+			continue
+		}
+		node, _ := task["node"].(string)
+		upid, _ := task["upid"].(string)
+
+		lines, err := client.GetItemListInterfaceArray(fmt.Sprintf("/nodes/%s/tasks/%s/log?limit=1000", node, upid))
+		if err != nil {
+			return nil, fmt.Errorf("error reading log of task %s: %s", upid, err)
+		}
+		for _, l := range lines {
+			line, _ := l.(map[string]interface{})
+			text, _ := line["t"].(string)
+			for _, f := range files {
+				if strings.Contains(text, f.Name) {
+					inUse[f.Name] = true
+				}
+			}
+		}
+	}
+	return inUse, nil
+}
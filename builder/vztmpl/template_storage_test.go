@@ -0,0 +1,128 @@
+package vztmpl
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Telmate/proxmox-api-go/proxmox"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersionedTemplateName(t *testing.T) {
+	taken := map[string]bool{
+		"debian-12-web_1_amd64": true,
+		"debian-12-web_2_amd64": true,
+		"web_custom":            true,
+		"web_custom-2":          true,
+	}
+	require.Equal(t, "debian-12-app_1_amd64", versionedTemplateName("debian-12-app_1_amd64", taken))
+	require.Equal(t, "debian-12-web_3_amd64", versionedTemplateName("debian-12-web_1_amd64", taken))
+	require.Equal(t, "web_custom-3", versionedTemplateName("web_custom", taken))
+
+	require.Equal(t, "12.0-2", nextRelease("12.0-1"))
+	require.Equal(t, "beta.1", nextRelease("beta"))
+}
+
+func TestExpiredTemplates(t *testing.T) {
+	now := time.Date(2023, 6, 30, 0, 0, 0, 0, time.UTC)
+	file := func(name string, daysAgo int) proxmox.Content_FileProperties {
+		return proxmox.Content_FileProperties{Name: name, CreationTime: now.AddDate(0, 0, -daysAgo)}
+	}
+	files := []proxmox.Content_FileProperties{
+		file("web_nightly-3.tar.gz", 1),
+		file("web_nightly.tar.gz", 10),
+		file("web_nightly-2.tar.zst", 5),
+		file("db_nightly.tar.gz", 20),
+		file("web_nightly-4.tar.gz", 0),
+		file("web_nightly-old.tar.gz", 30),
+	}
+	match := templateNameMatcher(&Config{}, &templateNameData{}, "web_nightly")
+
+	names := func(files []proxmox.Content_FileProperties) []string {
+		var names []string
+		for _, f := range files {
+			names = append(names, f.Name)
+		}
+		return names
+	}
+	require.Equal(t, []string{"web_nightly-2.tar.zst", "web_nightly.tar.gz"}, names(expiredTemplates(files, match, 2, 0, now)))
+	require.Equal(t, []string{"web_nightly.tar.gz"}, names(expiredTemplates(files, match, 0, 7, now)))
+	require.Equal(t, []string{"web_nightly.tar.gz"}, names(expiredTemplates(files, match, 1, 7, now)))
+	require.Empty(t, expiredTemplates(files, match, 5, 0, now))
+
+	appliance := templateNameMatcher(&Config{TemplateApplianceName: true, TemplateSuffix: "web"}, &templateNameData{OS: "debian", OSVersion: "12", Arch: "amd64"}, "debian-12-web_1_amd64")
+	require.True(t, appliance("debian-12-web_7_amd64"))
+	require.False(t, appliance("debian-12-db_7_amd64"))
+	require.False(t, appliance("debian-11-web_7_amd64"))
+}
+
+func TestParseDistributeTarget(t *testing.T) {
+	target, err := parseDistributeTarget("pve2:local", "pve1")
+	require.NoError(t, err)
+	require.Equal(t, distributeTarget{Node: "pve2", Storage: "local"}, target)
+
+	target, err = parseDistributeTarget("nfs", "pve1")
+	require.NoError(t, err)
+	require.Equal(t, distributeTarget{Node: "pve1", Storage: "nfs"}, target)
+
+	for _, s := range []string{"", "pve2:", ":local", "pve2:local:x"} {
+		_, err = parseDistributeTarget(s, "pve1")
+		require.Error(t, err, s)
+	}
+}
+
+func TestCheckDistributeTargets(t *testing.T) {
+	primary := distributeTarget{Node: "pve1", Storage: "local"}
+	targets := []distributeTarget{
+		{Node: "pve2", Storage: "local"},
+		{Node: "pve3", Storage: "local"},
+		{Node: "pve2", Storage: "nfs"},
+	}
+	require.NoError(t, checkDistributeTargets(primary, targets, map[distributeTarget]bool{targets[2]: true}))
+
+	targets = append(targets, distributeTarget{Node: "pve3", Storage: "nfs"})
+	shared := map[distributeTarget]bool{targets[2]: true, targets[3]: true}
+	require.Error(t, checkDistributeTargets(primary, targets, shared))
+
+	require.Equal(t, "pve2/local:vztmpl/web.tar.gz", targets[0].volid("web.tar.gz"))
+}
+
+func TestStoredTemplateNames(t *testing.T) {
+	contents := map[string][]string{
+		"/nodes/pve1/storage/local/content?content=vztmpl": {"local:vztmpl/web_custom.tar.gz", "local:vztmpl/db_custom.tar.zst"},
+		"/nodes/pve2/storage/local/content?content=vztmpl": {"local:vztmpl/web_custom.tar.xz", "local:vztmpl/web_custom-2.tar.gz"},
+	}
+	mockAPI := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		var data []map[string]interface{}
+		for _, volid := range contents[req.URL.RequestURI()] {
+			data = append(data, map[string]interface{}{"volid": volid})
+		}
+		_ = json.NewEncoder(rw).Encode(map[string]interface{}{"data": data})
+	}))
+	defer mockAPI.Close()
+
+	pmURL, _ := url.Parse(mockAPI.URL)
+	client, err := newProxmoxClient(Config{
+		proxmoxURL: pmURL,
+		Username:   "dummy@vmhost!test-token",
+		Token:      "token",
+	})
+	require.NoError(t, err)
+
+	c := &Config{
+		Node:                "pve1",
+		TemplateStoragePool: "local",
+		distributeTargets:   []distributeTarget{{Node: "pve2", Storage: "local"}},
+	}
+	names, err := storedTemplateNames(client, c)
+	require.NoError(t, err)
+	require.Equal(t, map[string][]distributeTarget{
+		"web_custom":   {{Node: "pve1", Storage: "local"}, {Node: "pve2", Storage: "local"}},
+		"db_custom":    {{Node: "pve1", Storage: "local"}},
+		"web_custom-2": {{Node: "pve2", Storage: "local"}},
+	}, names)
+}